@@ -1,7 +1,10 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
+	"os"
 	"time"
 
 	"github.com/arhantbararia/goat/manager"
@@ -13,7 +16,49 @@ import (
 
 var SLEEP_TIME = 7
 
+var (
+	runtimeName    = flag.String("runtime", "docker", "runtime for the demo worker: docker, or fake to run without a Docker daemon")
+	fakeRunTime    = flag.Duration("fake-run-time", 0, "fake runtime: how long containers run before exiting, 0 runs until stopped")
+	fakeCrashRate  = flag.Float64("fake-crash-rate", 0, "fake runtime: fraction of containers that crash")
+	fakeCrashAfter = flag.Duration("fake-crash-after", 30*time.Second, "fake runtime: how long crashing containers run before they die")
+	fakeFailRate   = flag.Float64("fake-fail-rate", 0, "fake runtime: fraction of starts that fail")
+	fakePullDelay  = flag.Duration("fake-pull-delay", 0, "fake runtime: simulated image pull time")
+	execDriver     = flag.Bool("exec-driver", false, "let the demo worker run tasks as plain host processes with the exec driver")
+	secretsFile    = flag.String("secrets-file", "", "file the manager persists encrypted secrets to, needs GOAT_SECRETS_KEY")
+)
+
+// newDrivers sets up the demo worker's drivers. The fake runtime also serves
+// tasks asking for docker, so existing task specs run unchanged.
+func newDrivers() map[string]task.Driver {
+	if *runtimeName == task.DriverFake {
+		fake := task.NewFake(task.FakeOptions{
+			PullDelay:     *fakePullDelay,
+			RunTime:       *fakeRunTime,
+			CrashAfter:    *fakeCrashAfter,
+			CrashRate:     *fakeCrashRate,
+			FailStartRate: *fakeFailRate,
+		})
+		return withExec(map[string]task.Driver{task.DriverDocker: fake, task.DriverFake: fake})
+	}
+
+	dock, err := task.NewDocker()
+	if err != nil {
+		log.Fatalf("Docker daemon unreachable: %v", err)
+	}
+	return withExec(map[string]task.Driver{task.DriverDocker: dock})
+}
+
+// withExec adds the exec driver when it was asked for, it runs tasks unconfined on the host
+func withExec(drivers map[string]task.Driver) map[string]task.Driver {
+	if *execDriver {
+		log.Println("exec driver enabled, tasks can run host processes")
+		drivers[task.DriverExec] = task.NewExec()
+	}
+	return drivers
+}
+
 func main() {
+	flag.Parse()
 
 	worker_host := "127.0.0.1"
 	worker_port := 8000
@@ -23,8 +68,9 @@ func main() {
 
 	fmt.Println("Starting Goat worker")
 	w := worker.Worker{
-		Queue: *queue.New(),
-		Db:    make(map[uuid.UUID]*task.Task),
+		Queue:   *queue.New(),
+		Db:      make(map[uuid.UUID]*task.Task),
+		Drivers: newDrivers(),
 	}
 
 	////// Starting Worker
@@ -33,6 +79,8 @@ func main() {
 
 	go w.CollectStats()
 
+	go w.UpdateTasks()
+
 	wapi := worker.API{
 		Address: worker_host,
 		Port:    worker_port,
@@ -47,6 +95,17 @@ func main() {
 
 	m := manager.New(workers)
 
+	// the key comes from the environment so it does not show up in the process list
+	if key := os.Getenv("GOAT_SECRETS_KEY"); key != "" {
+		secrets, err := manager.NewSecretStore(key, *secretsFile)
+		if err != nil {
+			log.Fatalf("Error opening secret store: %v", err)
+		}
+		m.Secrets = secrets
+	} else {
+		log.Println("GOAT_SECRETS_KEY not set, secrets are kept in memory with a random key")
+	}
+
 	mapi := manager.API{
 		Address: mhost,
 		Port:    mPort,
@@ -55,6 +114,9 @@ func main() {
 
 	go m.ProcessTasks()
 	go m.UpdateTasks()
+	go m.ReconcileServices()
+	go m.AutoscaleServices()
+	go m.ReconcileJobs()
 
 	go mapi.Start()
 
@@ -77,7 +139,7 @@ func main() {
 	}
 
 	for {
-		for _, t := range m.TaskDb {
+		for _, t := range m.GetTasks() {
 			fmt.Printf("[Manager] Task: id: %s, state: %d\n", t.ID, t.State)
 			time.Sleep(15 * time.Second)
 		}
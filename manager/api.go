@@ -19,8 +19,63 @@ func (a *API) initRouter() {
 	a.Router.Route("/tasks", func(r chi.Router) {
 		r.Post("/", a.StartTaskHandler)
 		r.Get("/", a.GetTasksHandler)
+		r.Get("/history/{name}", a.GetTaskHistoryHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
 			r.Delete("/", a.StopTaskHandler)
+			r.Get("/logs", a.GetTaskLogsHandler)
+			r.Get("/stats", a.GetTaskStatsHandler)
+			r.Post("/exec", a.ExecTaskHandler)
+			r.Get("/exec", a.ExecTaskInteractiveHandler)
+			r.Post("/pause", a.PauseTaskHandler)
+			r.Post("/resume", a.ResumeTaskHandler)
+			r.Post("/restart", a.RestartTaskHandler)
+		})
+	})
+	a.Router.Get("/deadletters", a.GetDeadLettersHandler)
+	a.Router.Route("/registries", func(r chi.Router) {
+		r.Get("/", a.GetRegistriesHandler)
+		r.Route("/{host}", func(r chi.Router) {
+			r.Put("/", a.SetRegistryHandler)
+			r.Delete("/", a.RemoveRegistryHandler)
+		})
+	})
+	a.Router.Route("/services", func(r chi.Router) {
+		r.Post("/", a.StartServiceHandler)
+		r.Get("/", a.GetServicesHandler)
+		r.Route("/{name}", func(r chi.Router) {
+			r.Get("/", a.GetServiceHandler)
+			r.Put("/", a.UpdateServiceHandler)
+			r.Put("/replicas", a.ScaleServiceHandler)
+			r.Put("/autoscale", a.SetAutoscaleHandler)
+			r.Get("/scaling", a.GetScalingHistoryHandler)
+			r.Get("/history", a.GetServiceHistoryHandler)
+			r.Post("/rollback", a.RollbackServiceHandler)
+			r.Get("/rollout", a.GetRolloutHandler)
+			r.Post("/rollout/pause", a.PauseRolloutHandler)
+			r.Post("/rollout/resume", a.ResumeRolloutHandler)
+			r.Delete("/", a.RemoveServiceHandler)
+		})
+	})
+	a.Router.Route("/jobs", func(r chi.Router) {
+		r.Post("/", a.StartJobHandler)
+		r.Get("/", a.GetJobsHandler)
+		r.Route("/{jobID}", func(r chi.Router) {
+			r.Get("/", a.GetJobHandler)
+			r.Delete("/", a.StopJobHandler)
+		})
+	})
+	a.Router.Route("/policies", func(r chi.Router) {
+		r.Get("/", a.GetPoliciesHandler)
+		r.Route("/{namespace}", func(r chi.Router) {
+			r.Put("/", a.SetPolicyHandler)
+			r.Delete("/", a.RemovePolicyHandler)
+		})
+	})
+	a.Router.Route("/secrets", func(r chi.Router) {
+		r.Get("/", a.GetSecretsHandler)
+		r.Route("/{name}", func(r chi.Router) {
+			r.Put("/", a.SetSecretHandler)
+			r.Delete("/", a.RemoveSecretHandler)
 		})
 	})
 }
@@ -0,0 +1,275 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/arhantbararia/goat/task"
+	"github.com/google/uuid"
+)
+
+// how often the manager checks the usage of autoscaled services
+var AUTOSCALE_TIME = 30
+
+// usage samples older than this are left out of scaling decisions
+var autoscaleWindow = 60 * time.Second
+
+// utilization within this share of the target does not change the replica count
+const autoscaleTolerance = 0.1
+
+// scaling decisions kept per service
+const maxScalingEvents = 100
+
+// cooldowns in seconds when Autoscale leaves them at 0
+const (
+	defaultScaleUpCooldown   = 60
+	defaultScaleDownCooldown = 300
+)
+
+var ErrAutoscaled = errors.New("service is autoscaled")
+
+// Autoscale lets the manager pick the replica count of a service from the
+// resource usage its workers report for its tasks
+type Autoscale struct {
+	MinReplicas       int
+	MaxReplicas       int
+	TargetCPU         float64 //average CPU percent per task, 100 is one core
+	TargetMemory      float64 //average memory use per task, in percent of its memory limit
+	ScaleUpCooldown   int     //seconds after a scaling decision before scaling up again, 60 when 0
+	ScaleDownCooldown int     //seconds after a scaling decision before scaling down again, 300 when 0
+}
+
+// ScalingEvent is a replica count change made by the autoscaler
+type ScalingEvent struct {
+	Time   time.Time
+	From   int
+	To     int
+	CPU    float64 //average utilization the decision was based on
+	Memory float64
+	Reason string
+}
+
+func (a *Autoscale) validate() task.ValidationError {
+	v := task.ValidationError{}
+	if a.MinReplicas < 0 {
+		v = append(v, task.FieldError{Field: "Autoscale.MinReplicas", Message: "must not be negative"})
+	}
+	if a.MaxReplicas < 1 || a.MaxReplicas < a.MinReplicas {
+		v = append(v, task.FieldError{Field: "Autoscale.MaxReplicas", Message: "must be at least 1 and MinReplicas"})
+	}
+	if a.TargetCPU < 0 {
+		v = append(v, task.FieldError{Field: "Autoscale.TargetCPU", Message: "must not be negative"})
+	}
+	if a.TargetMemory < 0 || a.TargetMemory > 100 {
+		v = append(v, task.FieldError{Field: "Autoscale.TargetMemory", Message: "must be between 0 and 100"})
+	}
+	if a.TargetCPU == 0 && a.TargetMemory == 0 {
+		v = append(v, task.FieldError{Field: "Autoscale", Message: "needs TargetCPU or TargetMemory"})
+	}
+	if a.ScaleUpCooldown < 0 {
+		v = append(v, task.FieldError{Field: "Autoscale.ScaleUpCooldown", Message: "must not be negative"})
+	}
+	if a.ScaleDownCooldown < 0 {
+		v = append(v, task.FieldError{Field: "Autoscale.ScaleDownCooldown", Message: "must not be negative"})
+	}
+	return v
+}
+
+func (a *Autoscale) cooldown(up bool) time.Duration {
+	if up {
+		if a.ScaleUpCooldown == 0 {
+			return defaultScaleUpCooldown * time.Second
+		}
+		return time.Duration(a.ScaleUpCooldown) * time.Second
+	}
+	if a.ScaleDownCooldown == 0 {
+		return defaultScaleDownCooldown * time.Second
+	}
+	return time.Duration(a.ScaleDownCooldown) * time.Second
+}
+
+func (a *Autoscale) clamp(replicas int) int {
+	return min(max(replicas, a.MinReplicas), a.MaxReplicas)
+}
+
+// SetAutoscale turns autoscaling of a service on, or off when a is nil
+func (m *Manager) SetAutoscale(name string, a *Autoscale) error {
+	if a != nil {
+		if v := a.validate(); len(v) > 0 {
+			return v
+		}
+	}
+
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+
+	s, ok := m.Services[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	s.Autoscale = a
+	if a != nil {
+		s.Replicas = a.clamp(s.Replicas)
+	}
+
+	return nil
+}
+
+// GetScalingHistory returns the scaling decisions made for a service, oldest first
+func (m *Manager) GetScalingHistory(name string) ([]ScalingEvent, error) {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+
+	s, ok := m.Services[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	events := slices.Clone(s.Scaling)
+	if events == nil {
+		events = []ScalingEvent{}
+	}
+	return events, nil
+}
+
+func (m *Manager) AutoscaleServices() {
+	for {
+		log.Println("Autoscaling services")
+		m.autoscaleServices()
+		time.Sleep(time.Duration(AUTOSCALE_TIME) * time.Second)
+	}
+}
+
+// autoscaleServices picks the running tasks of autoscaled services, asks their
+// workers for usage without holding any lock, then applies the decisions
+func (m *Manager) autoscaleServices() {
+	m.servicesMu.Lock()
+	m.mu.Lock()
+	running := map[string][]uuid.UUID{}
+	for name, s := range m.Services {
+		// a rollout moves tasks around on its own, scaling waits for it
+		if s.Autoscale == nil || s.Rollout.active() {
+			continue
+		}
+		running[name] = slices.DeleteFunc(slices.Clone(s.Tasks), func(id uuid.UUID) bool {
+			t, found := m.TaskDb[id]
+			return !found || t.State != task.Running
+		})
+	}
+	m.mu.Unlock()
+	m.servicesMu.Unlock()
+
+	for name, ids := range running {
+		cpu, memory, ok := m.utilization(ids)
+		if !ok {
+			continue
+		}
+
+		m.servicesMu.Lock()
+		// the service may have been removed or changed while its workers were asked
+		if s, found := m.Services[name]; found && s.Autoscale != nil && !s.Rollout.active() {
+			m.autoscale(s, cpu, memory)
+		}
+		m.servicesMu.Unlock()
+	}
+}
+
+// autoscale sets Replicas so the average utilization of the running tasks
+// of a service gets back to its target, reconcile then starts or stops tasks.
+// Called with m.servicesMu held.
+func (m *Manager) autoscale(s *Service, cpu float64, memory float64) {
+	a := s.Autoscale
+
+	// the metric asking for the most replicas wins
+	desired := 0
+	reason := ""
+	for _, metric := range []struct {
+		name          string
+		value, target float64
+	}{
+		{"cpu", cpu, a.TargetCPU},
+		{"memory", memory, a.TargetMemory},
+	} {
+		if metric.target == 0 {
+			continue
+		}
+		want := s.Replicas
+		ratio := metric.value / metric.target
+		if math.Abs(ratio-1) > autoscaleTolerance {
+			want = int(math.Ceil(float64(s.Replicas) * ratio))
+		}
+		if reason == "" || want > desired {
+			desired = want
+			reason = fmt.Sprintf("%s at %.1f%% against a target of %.1f%%", metric.name, metric.value, metric.target)
+		}
+	}
+	desired = a.clamp(desired)
+	if desired == s.Replicas {
+		return
+	}
+
+	up := desired > s.Replicas
+	if last := len(s.Scaling); last > 0 && time.Since(s.Scaling[last-1].Time) < a.cooldown(up) {
+		return
+	}
+
+	log.Printf("Autoscaling service %s from %d to %d replicas: %s \n", s.Name, s.Replicas, desired, reason)
+	s.Scaling = append(s.Scaling, ScalingEvent{
+		Time:   time.Now().UTC(),
+		From:   s.Replicas,
+		To:     desired,
+		CPU:    cpu,
+		Memory: memory,
+		Reason: reason,
+	})
+	if len(s.Scaling) > maxScalingEvents {
+		s.Scaling = s.Scaling[len(s.Scaling)-maxScalingEvents:]
+	}
+	s.Replicas = desired
+}
+
+// utilization averages the recent CPU and memory utilization of the given
+// tasks, ok is false while none of them reported usage yet
+func (m *Manager) utilization(running []uuid.UUID) (cpu float64, memory float64, ok bool) {
+	tasks := 0
+	for _, id := range running {
+		c, mem, sampled := m.taskUtilization(id)
+		if !sampled {
+			continue
+		}
+		cpu += c
+		memory += mem
+		tasks++
+	}
+	if tasks == 0 {
+		return 0, 0, false
+	}
+	return cpu / float64(tasks), memory / float64(tasks), true
+}
+
+func (m *Manager) taskUtilization(id uuid.UUID) (cpu float64, memory float64, ok bool) {
+	usage, err := m.GetTaskUsage(id)
+	if err != nil {
+		log.Printf("Error getting usage of task %v: %v \n", id, err)
+		return 0, 0, false
+	}
+
+	samples := 0
+	for _, u := range usage.History {
+		if time.Since(u.Time) > autoscaleWindow {
+			continue
+		}
+		cpu += u.CpuPercent
+		if u.MemoryLimit > 0 {
+			memory += float64(u.MemoryUsage) / float64(u.MemoryLimit) * 100
+		}
+		samples++
+	}
+	if samples == 0 {
+		return 0, 0, false
+	}
+	return cpu / float64(samples), memory / float64(samples), true
+}
@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arhantbararia/goat/task"
+)
+
+func autoscaledService(t *testing.T, m *Manager, a Autoscale) *Service {
+	t.Helper()
+	err := m.AddService(Service{Name: "web", Replicas: 2, Template: task.Task{Image: "img"}, Autoscale: &a})
+	if err != nil {
+		t.Fatalf("add service: %v", err)
+	}
+	return m.Services["web"]
+}
+
+func TestAutoscaleFollowsTheBusiestMetric(t *testing.T) {
+	m := New(nil)
+	s := autoscaledService(t, m, Autoscale{MinReplicas: 1, MaxReplicas: 10, TargetCPU: 50, TargetMemory: 50})
+
+	// cpu asks for 4 replicas, memory for 1
+	m.autoscale(s, 100, 20)
+	if s.Replicas != 4 {
+		t.Fatalf("got %d replicas, want 4", s.Replicas)
+	}
+	if len(s.Scaling) != 1 || s.Scaling[0].From != 2 || s.Scaling[0].To != 4 {
+		t.Errorf("scaling history is %+v, want one change from 2 to 4", s.Scaling)
+	}
+}
+
+func TestAutoscaleStaysWithinToleranceAndRange(t *testing.T) {
+	m := New(nil)
+	s := autoscaledService(t, m, Autoscale{MinReplicas: 1, MaxReplicas: 3, TargetCPU: 50})
+
+	m.autoscale(s, 52, 0)
+	if s.Replicas != 2 {
+		t.Fatalf("scaled to %d replicas for usage within the tolerance", s.Replicas)
+	}
+	m.autoscale(s, 500, 0)
+	if s.Replicas != 3 {
+		t.Fatalf("got %d replicas, want MaxReplicas 3", s.Replicas)
+	}
+}
+
+func TestAutoscaleWaitsOutItsCooldown(t *testing.T) {
+	m := New(nil)
+	s := autoscaledService(t, m, Autoscale{MinReplicas: 1, MaxReplicas: 10, TargetCPU: 50, ScaleDownCooldown: 60})
+
+	m.autoscale(s, 100, 0)
+	m.autoscale(s, 10, 0)
+	if s.Replicas != 4 {
+		t.Fatalf("scaled down to %d replicas during the cooldown", s.Replicas)
+	}
+
+	s.Scaling[0].Time = time.Now().Add(-time.Minute)
+	m.autoscale(s, 10, 0)
+	if s.Replicas != 1 {
+		t.Errorf("got %d replicas after the cooldown, want 1", s.Replicas)
+	}
+}
@@ -1,20 +1,29 @@
 package manager
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"time"
+	"os"
 
 	"github.com/arhantbararia/goat/task"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
+var auditLog = log.New(os.Stderr, "[audit] ", log.LstdFlags)
+
+var upgrader = websocket.Upgrader{}
+
 type ErrResponse struct {
 	HTTPStatusCode int
 	Message        string
+	Errors         []task.FieldError `json:",omitempty"` //field level problems of a rejected task spec
 }
 
 func (a *API) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
@@ -25,7 +34,8 @@ func (a *API) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 	err := d.Decode(&te)
 	if err != nil {
 		msg := fmt.Sprintf("Error serializing body: %v ", err)
-		log.Printf(msg)
+		log.Println(msg)
+		w.WriteHeader(400)
 		e := ErrResponse{
 			HTTPStatusCode: 400,
 			Message:        msg,
@@ -34,6 +44,31 @@ func (a *API) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = te.Task.Validate()
+	if err != nil {
+		log.Printf("Rejected task %v: %v\n", te.Task.ID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(422)
+		e := ErrResponse{
+			HTTPStatusCode: 422,
+			Message:        "invalid task spec",
+			Errors:         err.(task.ValidationError),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	err = a.Manager.CheckPolicy(te.Task)
+	if err != nil {
+		writePolicyError(w, err.(PolicyError))
+		return
+	}
+
+	// credentials and secret values are attached on dispatch, a client can't smuggle its own in
+	te.RegistryAuth = nil
+	te.Secrets = nil
+
+	a.Manager.RecordTaskSpec(te.Task)
 	a.Manager.AddTask(te)
 	log.Println("Added Task: ", te.Task.ID)
 	w.WriteHeader(201)
@@ -48,31 +83,877 @@ func (a *API) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *API) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
-	taskID := chi.URLParam(r, "taskID")
-	if taskID == "" {
-		log.Println("no tasks id in request")
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        fmt.Sprintf("invalid task id: %v", err),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	opts, err := task.ParseStopOptions(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        err.Error(),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	err = a.Manager.StopTask(tID, opts)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(404)
+		e := ErrResponse{
+			HTTPStatusCode: 404,
+			Message:        err.Error(),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// taskWorker resolves the worker running the task in the URL, answering the request itself on failure
+func (a *API) taskWorker(w http.ResponseWriter, r *http.Request) (uuid.UUID, string, bool) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
 		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        fmt.Sprintf("invalid task id: %v", err),
+		}
+		json.NewEncoder(w).Encode(e)
+		return tID, "", false
+	}
+
+	worker, ok := a.Manager.workerOf(tID)
+	if !ok {
+		w.WriteHeader(404)
+		e := ErrResponse{
+			HTTPStatusCode: 404,
+			Message:        fmt.Sprintf("task %v is not assigned to a worker", tID),
+		}
+		json.NewEncoder(w).Encode(e)
+		return tID, "", false
+	}
+
+	return tID, worker, true
+}
+
+// proxyToWorker forwards the request to the same path on the worker and streams the response back
+func (a *API) proxyToWorker(w http.ResponseWriter, r *http.Request, worker string) {
+	url := fmt.Sprintf("http://%s%s?%s", worker, r.URL.Path, r.URL.RawQuery)
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, url, r.Body)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("error connecting to worker %v: %v \n", worker, err)
+		w.WriteHeader(502)
+		e := ErrResponse{
+			HTTPStatusCode: 502,
+			Message:        fmt.Sprintf("worker %s unreachable", worker),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			rc.Flush()
+		}
+		if err != nil {
+			return
+		}
 	}
+}
 
-	tId, _ := uuid.Parse(taskID)
-	taskToStop, ok := a.Manager.TaskDb[tId]
+// GetTaskLogsHandler proxies a task's logs from the worker running it
+func (a *API) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	_, worker, ok := a.taskWorker(w, r)
 	if !ok {
-		log.Println("No task with task id: ", tId)
+		return
+	}
+
+	a.proxyToWorker(w, r, worker)
+}
+
+func (a *API) GetTaskStatsHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
 		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        fmt.Sprintf("invalid task id: %v", err),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
 	}
 
-	te := task.TaskEvent{
-		ID:        uuid.New(),
-		State:     task.Completed,
-		TimeStamp: time.Now(),
+	usage, err := a.Manager.GetTaskUsage(tID)
+	if err != nil {
+		log.Printf("error getting stats of task %v: %v \n", tID, err)
+		w.WriteHeader(404)
+		e := ErrResponse{
+			HTTPStatusCode: 404,
+			Message:        err.Error(),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
 	}
 
-	taskCopy := *taskToStop
-	taskCopy.State = task.Completed
-	te.Task = taskCopy
-	a.Manager.AddTask(te)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(usage)
+}
+
+func (a *API) PauseTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.taskAction(w, r, a.Manager.PauseTask)
+}
+
+func (a *API) ResumeTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.taskAction(w, r, a.Manager.ResumeTask)
+}
+
+func (a *API) RestartTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.taskAction(w, r, a.Manager.RestartTask)
+}
+
+// taskAction runs a pause, resume or restart on the task of the request, passing on the answer of its worker
+func (a *API) taskAction(w http.ResponseWriter, r *http.Request, action func(uuid.UUID) (task.Task, error)) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        fmt.Sprintf("invalid task id: %v", err),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	t, err := action(tID)
+	if err != nil {
+		log.Println(err)
+		e := ErrResponse{HTTPStatusCode: 502, Message: err.Error()}
+		var we WorkerError
+		switch {
+		case errors.Is(err, ErrTaskNotFound):
+			e.HTTPStatusCode = 404
+		case errors.As(err, &we):
+			e.HTTPStatusCode = we.StatusCode
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(e.HTTPStatusCode)
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(t)
+}
+
+func (a *API) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	tID, worker, ok := a.taskWorker(w, r)
+	if !ok {
+		return
+	}
+
+	// the body goes on to the worker, the audit line only needs what it runs and as whom
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        fmt.Sprintf("error reading exec request: %v", err),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	req := task.ExecRequest{}
+	json.Unmarshal(body, &req)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	auditLog.Printf("exec task=%v worker=%s cmd=%q user=%q from=%s", tID, worker, req.Cmd, req.User, r.RemoteAddr)
+	a.proxyToWorker(w, r, worker)
+}
+
+// ExecTaskInteractiveHandler relays an interactive exec websocket to the worker running the task
+func (a *API) ExecTaskInteractiveHandler(w http.ResponseWriter, r *http.Request) {
+	tID, worker, ok := a.taskWorker(w, r)
+	if !ok {
+		return
+	}
+
+	// upgrade first, so a plain GET never gets the worker to run the command
+	q := r.URL.Query()
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		auditLog.Printf("exec task=%v worker=%s cmd=%q user=%q interactive from=%s error=%v", tID, worker, q["cmd"], q.Get("user"), r.RemoteAddr, err)
+		return
+	}
+	defer conn.Close()
+
+	auditLog.Printf("exec task=%v worker=%s cmd=%q user=%q interactive from=%s", tID, worker, q["cmd"], q.Get("user"), r.RemoteAddr)
+
+	url := fmt.Sprintf("ws://%s%s?%s", worker, r.URL.Path, r.URL.RawQuery)
+	upstream, _, err := websocket.DefaultDialer.DialContext(r.Context(), url, nil)
+	if err != nil {
+		log.Printf("error opening exec session on worker %v: %v \n", worker, err)
+		conn.WriteJSON(struct{ Error string }{fmt.Sprintf("could not open exec session on worker %s", worker)})
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, ""))
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{})
+	go func() {
+		relayMessages(upstream, conn)
+		close(done)
+	}()
+	relayMessages(conn, upstream)
+	<-done
+
+	auditLog.Printf("exec task=%v worker=%s cmd=%q user=%q interactive from=%s closed", tID, worker, q["cmd"], q.Get("user"), r.RemoteAddr)
+}
+
+// relayMessages copies websocket messages until either side goes away
+func relayMessages(dst, src *websocket.Conn) {
+	for {
+		kind, data, err := src.ReadMessage()
+		if err != nil {
+			dst.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			dst.Close()
+			return
+		}
+		if err := dst.WriteMessage(kind, data); err != nil {
+			src.Close()
+			return
+		}
+	}
+}
+
+func (a *API) GetRegistriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.GetRegistries())
+}
+
+func (a *API) SetRegistryHandler(w http.ResponseWriter, r *http.Request) {
+	host := chi.URLParam(r, "host")
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	auth := task.RegistryAuth{}
+	err := d.Decode(&auth)
+	if err != nil {
+		msg := fmt.Sprintf("Error serializing body: %v ", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	a.Manager.SetRegistryAuth(host, auth)
+	log.Println("Stored credentials for registry: ", host)
+	w.WriteHeader(204)
+}
+
+func (a *API) RemoveRegistryHandler(w http.ResponseWriter, r *http.Request) {
+	host := chi.URLParam(r, "host")
+
+	a.Manager.RemoveRegistryAuth(host)
+	log.Println("Removed credentials for registry: ", host)
+	w.WriteHeader(204)
+}
+
+// SecretRequest is the body of a secret write, the value is never returned by the API
+type SecretRequest struct {
+	Value string
+}
+
+func (a *API) GetSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.Secrets.Names())
+}
+
+func (a *API) SetSecretHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	req := SecretRequest{}
+	err := d.Decode(&req)
+	if err != nil {
+		msg := fmt.Sprintf("Error serializing body: %v ", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	err = a.Manager.Secrets.Set(name, req.Value)
+	if err != nil {
+		msg := fmt.Sprintf("Error storing secret %s: %v", name, err)
+		log.Println(msg)
+		w.WriteHeader(500)
+		e := ErrResponse{
+			HTTPStatusCode: 500,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	log.Println("Stored secret: ", name)
+	w.WriteHeader(204)
+}
+
+func (a *API) RemoveSecretHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	err := a.Manager.Secrets.Remove(name)
+	if err != nil {
+		msg := fmt.Sprintf("Error removing secret %s: %v", name, err)
+		log.Println(msg)
+		w.WriteHeader(500)
+		e := ErrResponse{
+			HTTPStatusCode: 500,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
 
-	log.Printf("Added task event %v to stop %v \n", te.ID, taskToStop.ID)
+	log.Println("Removed secret: ", name)
 	w.WriteHeader(204)
+}
+
+func (a *API) StartServiceHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	s := Service{}
+	err := d.Decode(&s)
+	if err != nil {
+		msg := fmt.Sprintf("Error serializing body: %v ", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	err = a.Manager.AddService(s)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	log.Printf("Added service %s with %d replicas \n", s.Name, s.Replicas)
+	listing, _ := a.Manager.GetService(s.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(listing)
+}
+
+func (a *API) GetServicesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.GetServices())
+}
+
+func (a *API) GetServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	listing, ok := a.Manager.GetService(name)
+	if !ok {
+		w.WriteHeader(404)
+		e := ErrResponse{
+			HTTPStatusCode: 404,
+			Message:        fmt.Sprintf("service %s not found", name),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(listing)
+}
+
+// ScaleRequest is the body of a replica count change
+type ScaleRequest struct {
+	Replicas int
+}
+
+func (a *API) ScaleServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	req := ScaleRequest{}
+	err := d.Decode(&req)
+	if err != nil {
+		msg := fmt.Sprintf("Error serializing body: %v ", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	err = a.Manager.ScaleService(name, req.Replicas)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (a *API) RemoveServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	err := a.Manager.RemoveService(name)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	log.Println("Removed service: ", name)
+	w.WriteHeader(204)
+}
+
+// writeServiceError maps errors of service operations to their status code
+func writeServiceError(w http.ResponseWriter, err error) {
+	e := ErrResponse{Message: err.Error()}
+	var v task.ValidationError
+	var p PolicyError
+	switch {
+	case errors.As(err, &v):
+		e.HTTPStatusCode = 422
+		e.Message = "invalid service spec"
+		e.Errors = v
+	case errors.As(err, &p):
+		writePolicyError(w, p)
+		return
+	case errors.Is(err, ErrServiceNotFound), errors.Is(err, ErrUnknownVersion):
+		e.HTTPStatusCode = 404
+	case errors.Is(err, ErrServiceExists), errors.Is(err, ErrNoRollout), errors.Is(err, ErrAutoscaled):
+		e.HTTPStatusCode = 409
+	default:
+		e.HTTPStatusCode = 500
+	}
+
+	log.Println(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.HTTPStatusCode)
+	json.NewEncoder(w).Encode(e)
+}
+
+// UpdateServiceRequest is the body of a service update, Update keeps the current settings when nil
+type UpdateServiceRequest struct {
+	Template task.Task
+	Update   *UpdateConfig
+}
+
+func (a *API) UpdateServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	req := UpdateServiceRequest{}
+	err := d.Decode(&req)
+	if err != nil {
+		msg := fmt.Sprintf("Error serializing body: %v ", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	err = a.Manager.UpdateService(name, req.Template, req.Update)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	rollout, _ := a.Manager.GetRollout(name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(202)
+	json.NewEncoder(w).Encode(rollout)
+}
+
+func (a *API) GetRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	rollout, err := a.Manager.GetRollout(name)
+	if errors.Is(err, ErrNoRollout) {
+		w.WriteHeader(404)
+		e := ErrResponse{
+			HTTPStatusCode: 404,
+			Message:        err.Error(),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(rollout)
+}
+
+func (a *API) PauseRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	err := a.Manager.PauseRollout(chi.URLParam(r, "name"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (a *API) ResumeRolloutHandler(w http.ResponseWriter, r *http.Request) {
+	err := a.Manager.ResumeRollout(chi.URLParam(r, "name"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (a *API) GetTaskHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	versions, ok := a.Manager.GetTaskSpecs(name)
+	if !ok {
+		w.WriteHeader(404)
+		e := ErrResponse{
+			HTTPStatusCode: 404,
+			Message:        fmt.Sprintf("no task submitted as %s", name),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(versions)
+}
+
+func (a *API) GetServiceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	history, err := a.Manager.GetServiceHistory(chi.URLParam(r, "name"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(history)
+}
+
+// RollbackRequest picks the version to roll back to, the previous one when Version is 0
+type RollbackRequest struct {
+	Version int
+}
+
+func (a *API) RollbackServiceHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	req := RollbackRequest{}
+	if r.ContentLength != 0 {
+		d := json.NewDecoder(r.Body)
+		d.DisallowUnknownFields()
+		err := d.Decode(&req)
+		if err != nil {
+			msg := fmt.Sprintf("Error serializing body: %v ", err)
+			log.Println(msg)
+			w.WriteHeader(400)
+			e := ErrResponse{
+				HTTPStatusCode: 400,
+				Message:        msg,
+			}
+			json.NewEncoder(w).Encode(e)
+			return
+		}
+	}
+
+	err := a.Manager.RollbackService(name, req.Version)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	rollout, _ := a.Manager.GetRollout(name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(202)
+	json.NewEncoder(w).Encode(rollout)
+}
+
+func (a *API) SetAutoscaleHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	// a body of null turns autoscaling off
+	var autoscale *Autoscale
+	err := d.Decode(&autoscale)
+	if err != nil {
+		msg := fmt.Sprintf("Error serializing body: %v ", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	err = a.Manager.SetAutoscale(name, autoscale)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (a *API) GetScalingHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	events, err := a.Manager.GetScalingHistory(chi.URLParam(r, "name"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(events)
+}
+
+func writeJobError(w http.ResponseWriter, err error) {
+	e := ErrResponse{Message: err.Error()}
+	var v task.ValidationError
+	var p PolicyError
+	switch {
+	case errors.As(err, &v):
+		e.HTTPStatusCode = 422
+		e.Message = "invalid job spec"
+		e.Errors = v
+	case errors.As(err, &p):
+		writePolicyError(w, p)
+		return
+	case errors.Is(err, ErrJobNotFound):
+		e.HTTPStatusCode = 404
+	default:
+		e.HTTPStatusCode = 500
+	}
+
+	log.Println(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.HTTPStatusCode)
+	json.NewEncoder(w).Encode(e)
+}
+
+// jobID parses the job ID of a request, answering 400 when it is invalid
+func jobID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "jobID"))
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        fmt.Sprintf("invalid job id: %v", err),
+		}
+		json.NewEncoder(w).Encode(e)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func (a *API) StartJobHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	j := Job{}
+	err := d.Decode(&j)
+	if err != nil {
+		msg := fmt.Sprintf("Error serializing body: %v ", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	status, err := a.Manager.AddJob(j)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(status)
+}
+
+func (a *API) GetJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.GetJobs())
+}
+
+func (a *API) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := a.Manager.GetJob(id)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(status)
+}
+
+func (a *API) StopJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := jobID(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.Manager.StopJob(id)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+
+	log.Println("Stopped job: ", id)
+	w.WriteHeader(204)
+}
+
+func (a *API) GetDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.GetDeadLetters())
+}
+
+func writePolicyError(w http.ResponseWriter, err PolicyError) {
+	log.Println(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(403)
+	e := ErrResponse{
+		HTTPStatusCode: 403,
+		Message:        fmt.Sprintf("forbidden by the security policy of namespace %s", err.Namespace),
+		Errors:         err.Errors,
+	}
+	json.NewEncoder(w).Encode(e)
+}
+
+func (a *API) GetPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Manager.GetPolicies())
+}
+
+func (a *API) SetPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	p := SecurityPolicy{}
+	err := d.Decode(&p)
+	if err != nil {
+		msg := fmt.Sprintf("Error serializing body: %v ", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	err = a.Manager.SetPolicy(namespace, p)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(422)
+		e := ErrResponse{
+			HTTPStatusCode: 422,
+			Message:        "invalid security policy",
+			Errors:         err.(task.ValidationError),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	log.Println("Set security policy of namespace: ", namespace)
+	w.WriteHeader(204)
+}
+
+func (a *API) RemovePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	namespace := chi.URLParam(r, "namespace")
+
+	a.Manager.RemovePolicy(namespace)
+	log.Println("Removed security policy of namespace: ", namespace)
+	w.WriteHeader(204)
 }
@@ -0,0 +1,317 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arhantbararia/goat/task"
+	"github.com/google/uuid"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// states of a job
+const (
+	JobRunning   = "Running"
+	JobSucceeded = "Succeeded"
+	JobFailed    = "Failed"
+	JobStopped   = "Stopped"
+)
+
+// Job runs its Template once per index as batch tasks, e.g. once per shard
+type Job struct {
+	ID          uuid.UUID
+	Name        string
+	Template    task.Task
+	Count       int                 //number of indexes, len(Params) when 0
+	Params      []map[string]string `json:",omitempty"` //env vars set for each index
+	Parallelism int                 //tasks running at once, all of them when 0
+	Completions int                 //tasks that have to succeed for the job to succeed, Count when 0
+	State       string
+	Indexes     []JobIndex
+	CreatedAt   time.Time
+	FinishedAt  time.Time
+}
+
+// JobIndex is one task of a job
+type JobIndex struct {
+	Index  int
+	TaskID uuid.UUID //nil until the task is started
+	State  task.State
+	Error  string
+}
+
+// JobStatus is a job as shown by the manager API, with its tasks counted by outcome
+type JobStatus struct {
+	*Job
+	Pending   int //indexes not started yet
+	Running   int //tasks started and not finished
+	Succeeded int
+	Failed    int
+}
+
+func (j *Job) validate() error {
+	v := task.ValidationError{}
+	if j.Count < 0 {
+		v = append(v, task.FieldError{Field: "Count", Message: "must not be negative"})
+	}
+	if j.Count == 0 && len(j.Params) == 0 {
+		v = append(v, task.FieldError{Field: "Count", Message: "is required without Params"})
+	}
+	if j.Count > 0 && len(j.Params) > 0 && j.Count != len(j.Params) {
+		v = append(v, task.FieldError{Field: "Count", Message: fmt.Sprintf("must match the %d Params", len(j.Params))})
+	}
+	for i, params := range j.Params {
+		for k := range params {
+			if k == "" || strings.Contains(k, "=") {
+				v = append(v, task.FieldError{Field: fmt.Sprintf("Params[%d]", i), Message: fmt.Sprintf("invalid env var name %q", k)})
+			}
+		}
+	}
+	if j.Parallelism < 0 {
+		v = append(v, task.FieldError{Field: "Parallelism", Message: "must not be negative"})
+	}
+	if j.Completions < 0 || j.Completions > max(j.Count, len(j.Params)) {
+		v = append(v, task.FieldError{Field: "Completions", Message: "must be between 0 and Count"})
+	}
+	if j.Template.Type != "" && j.Template.Type != task.TypeBatch {
+		v = append(v, task.FieldError{Field: "Template.Type", Message: "must be batch"})
+	}
+	if len(v) > 0 {
+		return v
+	}
+
+	// the template gets its ID when tasks are created from it
+	t := j.Template
+	t.ID = uuid.New()
+	return templateError(t.Validate())
+}
+
+func (j *Job) parallelism() int {
+	if j.Parallelism == 0 {
+		return j.Count
+	}
+	return j.Parallelism
+}
+
+func (j *Job) completions() int {
+	if j.Completions == 0 {
+		return j.Count
+	}
+	return j.Completions
+}
+
+// AddJob registers a new job, the reconcile loop starts its tasks
+func (m *Manager) AddJob(j Job) (JobStatus, error) {
+	err := j.validate()
+	if err != nil {
+		return JobStatus{}, err
+	}
+	err = m.CheckPolicy(j.Template)
+	if err != nil {
+		return JobStatus{}, err
+	}
+
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+
+	j.ID = uuid.New()
+	if j.Name == "" {
+		j.Name = "job-" + j.ID.String()[:8]
+	}
+	if j.Count == 0 {
+		j.Count = len(j.Params)
+	}
+	j.Template = j.Template.Spec()
+	j.Template.Type = task.TypeBatch
+	j.State = JobRunning
+	j.Indexes = make([]JobIndex, j.Count)
+	for i := range j.Indexes {
+		j.Indexes[i] = JobIndex{Index: i, State: task.Pending}
+	}
+	j.CreatedAt = time.Now().UTC()
+	j.FinishedAt = time.Time{}
+	m.Jobs[j.ID] = &j
+	log.Printf("Added job %s (%v) with %d tasks \n", j.Name, j.ID, j.Count)
+
+	return jobStatus(&j), nil
+}
+
+// StopJob stops the running tasks of a job and starts no more of them
+func (m *Manager) StopJob(id uuid.UUID) error {
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.Jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrJobNotFound, id)
+	}
+	if j.State == JobRunning {
+		m.finishJob(j, JobStopped)
+	}
+
+	return nil
+}
+
+func (m *Manager) GetJobs() []JobStatus {
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+
+	jobs := []JobStatus{}
+	for _, j := range m.Jobs {
+		jobs = append(jobs, jobStatus(j))
+	}
+	slices.SortFunc(jobs, func(a, b JobStatus) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return jobs
+}
+
+func (m *Manager) GetJob(id uuid.UUID) (JobStatus, error) {
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+
+	j, ok := m.Jobs[id]
+	if !ok {
+		return JobStatus{}, fmt.Errorf("%w: %v", ErrJobNotFound, id)
+	}
+	return jobStatus(j), nil
+}
+
+func jobStatus(j *Job) JobStatus {
+	copied := *j
+	copied.Indexes = slices.Clone(j.Indexes)
+	status := JobStatus{Job: &copied}
+	for _, idx := range j.Indexes {
+		switch {
+		case idx.TaskID == uuid.Nil:
+			status.Pending++
+		case idx.State == task.Completed:
+			status.Succeeded++
+		case idx.State == task.Failed:
+			status.Failed++
+		default:
+			status.Running++
+		}
+	}
+	return status
+}
+
+func (m *Manager) ReconcileJobs() {
+	for {
+		log.Println("Reconciling jobs")
+		m.reconcileJobs()
+		time.Sleep(time.Duration(RECONCILE_TIME) * time.Second)
+	}
+}
+
+func (m *Manager) reconcileJobs() {
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, j := range m.Jobs {
+		if j.State == JobRunning {
+			m.reconcileJob(j)
+		}
+	}
+}
+
+// reconcileJob picks up the outcome of the tasks of a job, decides whether the
+// job succeeded or can no longer succeed, and otherwise starts the next
+// indexes within Parallelism
+func (m *Manager) reconcileJob(j *Job) {
+	for i := range j.Indexes {
+		idx := &j.Indexes[i]
+		if idx.TaskID == uuid.Nil {
+			continue
+		}
+		if t, ok := m.TaskDb[idx.TaskID]; ok {
+			idx.State = t.State
+			idx.Error = t.Error
+		}
+	}
+
+	status := jobStatus(j)
+	switch {
+	case status.Succeeded >= j.completions():
+		m.finishJob(j, JobSucceeded)
+		return
+	case j.Count-status.Failed < j.completions():
+		m.finishJob(j, JobFailed)
+		return
+	}
+
+	running := status.Running
+	for i := range j.Indexes {
+		if running >= j.parallelism() {
+			break
+		}
+		if j.Indexes[i].TaskID != uuid.Nil {
+			continue
+		}
+		m.startJobTask(j, i)
+		running++
+	}
+}
+
+// startJobTask queues the task of one index of a job
+func (m *Manager) startJobTask(j *Job, i int) {
+	t := j.Template
+	t.ID = uuid.New()
+	t.Name = fmt.Sprintf("%s-%d", j.Name, i)
+	t.State = task.Scheduled
+	t.Job = j.ID.String()
+	t.Env = slices.Clone(j.Template.Env)
+	t.Env = append(t.Env,
+		fmt.Sprintf("GOAT_JOB_ID=%s", j.ID),
+		fmt.Sprintf("GOAT_JOB_INDEX=%d", i),
+		fmt.Sprintf("GOAT_JOB_COUNT=%d", j.Count),
+	)
+	if i < len(j.Params) {
+		for _, k := range slices.Sorted(maps.Keys(j.Params[i])) {
+			t.Env = append(t.Env, fmt.Sprintf("%s=%s", k, j.Params[i][k]))
+		}
+	}
+
+	m.addTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		TimeStamp: time.Now().UTC(),
+		Task:      t,
+	})
+	j.Indexes[i].TaskID = t.ID
+	j.Indexes[i].State = task.Scheduled
+	log.Printf("Started task %v for index %d of job %s \n", t.ID, i, j.Name)
+}
+
+// finishJob settles a job and stops the tasks it no longer needs
+func (m *Manager) finishJob(j *Job, state string) {
+	j.State = state
+	j.FinishedAt = time.Now().UTC()
+	log.Printf("Job %s %s \n", j.Name, strings.ToLower(state))
+
+	for i := range j.Indexes {
+		idx := &j.Indexes[i]
+		if idx.TaskID == uuid.Nil || idx.State == task.Completed || idx.State == task.Failed {
+			continue
+		}
+		_, dispatched := m.TaskDb[idx.TaskID]
+		err := m.stopTask(idx.TaskID, nil)
+		if err != nil {
+			log.Printf("Error stopping task %v of job %s: %v \n", idx.TaskID, j.Name, err)
+			continue
+		}
+		// its start was cancelled before it reached a worker, so it never ran
+		if !dispatched {
+			idx.TaskID = uuid.Nil
+			idx.State = task.Pending
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/arhantbararia/goat/task"
+)
+
+// finished reports whether the job left the running state
+func finished(m *Manager, j JobStatus) func() bool {
+	return func() bool {
+		got, err := m.GetJob(j.ID)
+		return err == nil && got.State != JobRunning
+	}
+}
+
+func TestJobSucceedsWhenItsTasksComplete(t *testing.T) {
+	m := New([]string{startWorker(t, task.FakeOptions{RunTime: 100 * time.Millisecond})})
+	j, err := m.AddJob(Job{Count: 3, Parallelism: 2, Template: task.Task{Image: "img"}})
+	if err != nil {
+		t.Fatalf("add job: %v", err)
+	}
+	settle(t, m, func() bool {
+		got, _ := m.GetJob(j.ID)
+		if got.Running > 2 {
+			t.Fatalf("job runs %d tasks at once, want at most 2", got.Running)
+		}
+		return got.State != JobRunning
+	})
+
+	got, _ := m.GetJob(j.ID)
+	if got.State != JobSucceeded || got.Succeeded != 3 || got.Failed != 0 {
+		t.Errorf("job %s with %d succeeded and %d failed tasks, want all 3 succeeded", got.State, got.Succeeded, got.Failed)
+	}
+}
+
+func TestJobFailsOnceItCanNoLongerComplete(t *testing.T) {
+	m := New([]string{startWorker(t, task.FakeOptions{CrashRate: 1, CrashAfter: 100 * time.Millisecond})})
+	j, err := m.AddJob(Job{Count: 3, Completions: 2, Template: task.Task{Image: "img"}})
+	if err != nil {
+		t.Fatalf("add job: %v", err)
+	}
+	settle(t, m, finished(m, j))
+
+	got, _ := m.GetJob(j.ID)
+	if got.State != JobFailed || got.Succeeded != 0 || got.Failed < 2 {
+		t.Errorf("job %s with %d succeeded and %d failed tasks, want it failed after 2 failures", got.State, got.Succeeded, got.Failed)
+	}
+}
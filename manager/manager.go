@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/arhantbararia/goat/task"
@@ -21,20 +23,83 @@ type Manager struct {
 	Workers       []string
 	WorkerTaskMap map[string][]uuid.UUID
 	TaskWorkerMap map[uuid.UUID]string
-	LastWorker    int //index to last used worker. Next chosen will be from LastWorker+1 (Round robin)
+	WorkerDrivers map[string][]string          //runtime drivers advertised by each worker
+	Registries    map[string]task.RegistryAuth //registry host -> credentials handed to workers on dispatch
+	Policies      map[string]SecurityPolicy    //security policy of each namespace that has one
+	Secrets       *SecretStore
+	Services      map[string]*Service
+	Jobs          map[uuid.UUID]*Job
+	DeadLetters   []DeadLetter                 //events that ran out of dispatch attempts, oldest first
+	LastSeen      map[uuid.UUID]time.Time      //when a worker last reported each task
+	TaskSpecs     map[string][]TaskSpecVersion //specs submitted under each task name, oldest first
+	LastWorker    int                          //index to last used worker. Next chosen will be from LastWorker+1 (Round robin)
+
+	retries    map[uuid.UUID]*retry         //failed dispatches of events still queued or not started yet
+	starting   map[uuid.UUID]task.TaskEvent //start event of each task a worker took and has not started yet
+	servicesMu sync.Mutex
+	jobsMu     sync.Mutex
+	policiesMu sync.Mutex
+	// mu guards the task state: Pending, TaskDb, EventDb, the worker maps,
+	// LastSeen, TaskSpecs, Registries, retries, starting and DeadLetters. It is taken
+	// after servicesMu or jobsMu and never held across requests to workers.
+	mu sync.Mutex
 }
 
-func (m *Manager) SelectWorker() string {
-	var newWorkerIndex int
-	if m.LastWorker+1 < len(m.Workers) {
-		newWorkerIndex = m.LastWorker + 1
-	} else {
-		newWorkerIndex = 0
-		m.LastWorker = 0
+// SelectWorker picks the next worker, round robin, among those supporting the task's driver.
+// The avoided worker, e.g. one that just failed to take the task, is only picked when no other fits.
+// Called with m.mu held.
+func (m *Manager) SelectWorker(t task.Task, avoid string) (string, error) {
+	driver := task.DriverName(t)
+	fallback := -1
+	for i := 1; i <= len(m.Workers); i++ {
+		idx := (m.LastWorker + i) % len(m.Workers)
+		w := m.Workers[idx]
+		// a worker that could not be asked yet stays a candidate, dispatch will retry it
+		drivers, known := m.WorkerDrivers[w]
+		if !known || slices.Contains(drivers, driver) {
+			if w == avoid {
+				fallback = idx
+				continue
+			}
+			m.LastWorker = idx
+			return w, nil
+		}
+	}
+	if fallback >= 0 {
+		m.LastWorker = fallback
+		return m.Workers[fallback], nil
 	}
 
-	return m.Workers[newWorkerIndex]
+	return "", fmt.Errorf("no worker supports driver %s", driver)
+}
+
+func (m *Manager) updateWorkerDrivers(worker string) {
+	url := fmt.Sprintf("http://%s/drivers", worker)
+	resp, err := http.Get(url)
+	if err != nil {
+		log.Printf("Error connecting to %v , %v\n", worker, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var drivers []string
+	err = json.NewDecoder(resp.Body).Decode(&drivers)
+	if err != nil {
+		log.Println("Error serializing drivers data: ", err)
+		return
+	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.WorkerDrivers[worker] = drivers
+}
+
+// knowsDrivers reports whether a worker has told the manager which drivers it supports
+func (m *Manager) knowsDrivers(worker string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.WorkerDrivers[worker]
+	return ok
 }
 
 func (m *Manager) updateTasks() {
@@ -44,26 +109,59 @@ func (m *Manager) updateTasks() {
 		resp, err := http.Get(url)
 		if err != nil {
 			log.Printf("Error connecting to %v , %v\n", worker, err)
+			continue
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			log.Println("error sending request", err)
+			log.Printf("Worker %v answered %d to a task update \n", worker, resp.StatusCode)
+			resp.Body.Close()
+			continue
 		}
 
 		d := json.NewDecoder(resp.Body)
 		var tasks []*task.Task
 		err = d.Decode(&tasks)
+		resp.Body.Close()
 		if err != nil {
 			log.Println("Error serializing tasks data: ", err)
 		}
 
+		m.mu.Lock()
 		for _, t := range tasks {
 			log.Println("Updating Task ", t.ID)
 
 			_, ok := m.TaskDb[t.ID]
 			if !ok {
+				// e.g. started before the manager restarted, the other tasks still need their update
 				log.Println("No Tasks with this Task ID: ", t.ID)
-				return
+				continue
+			}
+
+			// the task was moved off this worker after its start failed there
+			if m.TaskWorkerMap[t.ID] != worker {
+				continue
+			}
+
+			m.LastSeen[t.ID] = time.Now()
+			if m.TaskDb[t.ID].FinishReason == task.ReasonLost {
+				// replaced already, make sure it does not keep running next to its replacement
+				if t.State == task.Running || t.State == task.Paused {
+					log.Printf("Lost task %v is back, stopping it \n", t.ID)
+					m.stopTask(t.ID, nil)
+				}
+				continue
+			}
+
+			if te, ok := m.starting[t.ID]; ok {
+				if t.State == task.Failed && t.FinishReason == task.ReasonStartFailed {
+					// retried like a failed dispatch, the task stays scheduled meanwhile
+					delete(m.starting, t.ID)
+					m.dispatchFailed(te, worker, fmt.Errorf("start failed: %s", t.Error), true)
+					continue
+				}
+				if t.State != task.Scheduled {
+					m.started(t.ID)
+				}
 			}
 
 			if m.TaskDb[t.ID].State != t.State {
@@ -71,76 +169,178 @@ func (m *Manager) updateTasks() {
 			}
 
 			m.TaskDb[t.ID].StartTime = t.StartTime
-			m.TaskDb[t.ID].FinishTime = t.StartTime
+			m.TaskDb[t.ID].FinishTime = t.FinishTime
 			m.TaskDb[t.ID].ContainerID = t.ContainerID
+			m.TaskDb[t.ID].ExitCode = t.ExitCode
+			m.TaskDb[t.ID].OOMKilled = t.OOMKilled
+			m.TaskDb[t.ID].Error = t.Error
+			m.TaskDb[t.ID].FinishReason = t.FinishReason
+			m.TaskDb[t.ID].Members = t.Members
+			m.TaskDb[t.ID].Health = t.Health
 
 		}
+		m.mu.Unlock()
 
 	}
 }
 
 func (m *Manager) SendWork() {
-	if m.Pending.Len() > 0 {
-		w := m.SelectWorker()
-
-		e := m.Pending.Dequeue()
+	// ask workers that never answered which drivers they support before picking one
+	for _, w := range m.Workers {
+		if !m.knowsDrivers(w) {
+			m.updateWorkerDrivers(w)
+		}
+	}
 
-		te := e.(task.TaskEvent)
+	m.mu.Lock()
+	te, w, secrets, ok := m.assign()
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	t := te.Task
+	dispatched := te
+	// credentials are only needed to pull, the worker would keep them for a stop forever
+	if te.State != task.Completed {
+		dispatched.RegistryAuth = m.registryAuthFor(t.Image)
+	}
+	dispatched.Secrets = secrets
+	m.mu.Unlock()
 
-		t := te.Task
-		log.Printf("Pulled %v off pending queue \n", t)
-		m.EventDb[te.ID] = &te
-		m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], te.Task.ID)
-		m.TaskWorkerMap[t.ID] = w
+	data, err := json.Marshal(dispatched)
+	if err != nil {
+		log.Println("Unable to serialize task")
+	}
 
-		t.State = task.Scheduled
-		m.TaskDb[t.ID] = &t
+	url := fmt.Sprintf("http://%s/tasks", w)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		m.mu.Lock()
+		m.dispatchFailed(te, w, fmt.Errorf("error connecting to worker: %v", err), true)
+		m.mu.Unlock()
+		return
+	}
+	defer resp.Body.Close()
 
-		data, err := json.Marshal(te)
+	d := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		e := worker.ErrResponse{}
+		err := d.Decode(&e)
 		if err != nil {
-			log.Println("Unable to serialize task")
+			e.Message = fmt.Sprintf("undecodable response: %v", err)
 		}
+		m.mu.Lock()
+		m.dispatchFailed(te, w, fmt.Errorf("worker answered %d: %s", resp.StatusCode, e.Message), retryableStatus(resp.StatusCode))
+		m.mu.Unlock()
+		return
+	}
+	m.mu.Lock()
+	if te.State == task.Completed {
+		delete(m.retries, te.ID)
+	} else {
+		// the start may still fail on the worker, its attempts are kept until it is running.
+		// Taking a start counts as a report, a worker that dies before starting it leaves the task lost
+		m.starting[t.ID] = te
+		m.LastSeen[t.ID] = time.Now()
+	}
+	m.mu.Unlock()
 
-		url := fmt.Sprintf("http://%s/tasks", w)
-		resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
+	t = task.Task{}
+	err = d.Decode(&t)
+	if err != nil {
+		fmt.Printf("Error decoding response: %s \n", err.Error())
+		return
+	}
+	log.Printf("%v \n", t)
+}
 
-		if err != nil {
-			log.Printf("error connecting to worker: %v : %v \n", w, err)
-			m.Pending.Enqueue(te)
-			return
-		}
+// assign takes the next event off the queue and picks the worker it goes to,
+// along with the secret values a task to start needs. Tasks that can't be
+// started are marked failed. Called with m.mu held.
+func (m *Manager) assign() (task.TaskEvent, string, map[string]string, bool) {
+	te, ok := m.nextEvent()
+	if !ok {
+		log.Println("No tasks in the queue")
+		return te, "", nil, false
+	}
 
-		d := json.NewDecoder(resp.Body)
-		if resp.StatusCode != http.StatusCreated {
-			e := worker.ErrResponse{}
-			err := d.Decode(&e)
-			if err != nil {
-				fmt.Println("Error decoding response: %s \n", err.Error())
-				return
-			}
-			log.Printf("Response error (%d): %s", e.HTTPStatusCode, e.Message)
+	t := te.Task
+	log.Printf("Pulled %v off pending queue \n", t)
+	stop := te.State == task.Completed
 
-			return
+	// secret values only go out with a start, a stop must not fail on a secret deleted meanwhile
+	var secrets map[string]string
+	var err error
+	if !stop {
+		secrets, err = m.secretsFor(t)
+		if err != nil {
+			log.Printf("Unable to resolve secrets of task %v: %v \n", t.ID, err)
+			t.State = task.Failed
+			t.RecordStartFailure(err)
+			m.TaskDb[t.ID] = &t
+			return te, "", nil, false
+		}
+	}
 
+	// the policy may have changed since the task was submitted
+	if !stop {
+		err = m.CheckPolicy(t)
+		if err != nil {
+			log.Printf("Refusing to start task %v: %v \n", t.ID, err)
+			t.State = task.Failed
+			t.RecordStartFailure(err)
+			m.TaskDb[t.ID] = &t
+			return te, "", nil, false
 		}
+	}
 
-		t = task.Task{}
-		err = d.Decode(&t)
+	// a stop has to reach the worker running the task, a task no worker has
+	// is stopped right here and its start, if still queued, is dropped
+	w, assigned := m.TaskWorkerMap[t.ID]
+	if stop && !assigned {
+		if cur, ok := m.TaskDb[t.ID]; ok && cur.State != task.Completed && cur.State != task.Failed {
+			cur.State = task.Completed
+			cur.FinishTime = time.Now().UTC()
+			cur.FinishReason = task.ReasonStopped
+		}
+		log.Printf("Task %v is not on any worker, marked it stopped \n", t.ID)
+		return te, "", nil, false
+	}
+	if cur, ok := m.TaskDb[t.ID]; ok && !stop && cur.State == task.Completed {
+		delete(m.retries, te.ID)
+		log.Printf("Task %v was stopped before it reached a worker, dropping its start \n", t.ID)
+		return te, "", nil, false
+	}
+	if !stop {
+		w, err = m.SelectWorker(t, m.lastFailedWorker(te.ID))
 		if err != nil {
-			fmt.Printf("Error decoding response: %s \n", err.Error())
-			return
+			log.Printf("Unable to place task %v: %v \n", t.ID, err)
+			t.State = task.Failed
+			t.RecordStartFailure(err)
+			m.TaskDb[t.ID] = &t
+			return te, "", nil, false
 		}
-		log.Printf("%v \n", t)
+	}
 
-	} else {
-		log.Println("No tasks in the queue")
+	m.EventDb[te.ID] = &te
+	if !stop {
+		m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], te.Task.ID)
+		m.TaskWorkerMap[t.ID] = w
+
+		t.State = task.Scheduled
+		m.TaskDb[t.ID] = &t
 	}
+
+	return te, w, secrets, true
 }
 
 func (m *Manager) UpdateTasks() {
 	for {
 		fmt.Printf("[Manager] Updating Tasks from %d workers \n", len(m.Workers))
 		m.updateTasks()
+		for _, w := range m.Workers {
+			m.updateWorkerDrivers(w)
+		}
 		time.Sleep(15 * time.Second)
 	}
 }
@@ -155,9 +355,100 @@ func (m *Manager) ProcessTasks() {
 }
 
 func (m *Manager) AddTask(te task.TaskEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addTask(te)
+}
+
+func (m *Manager) addTask(te task.TaskEvent) {
 	m.Pending.Enqueue(te)
 }
 
+// TaskSpecVersion is one submission of a task spec
+type TaskSpecVersion struct {
+	Version     int
+	TaskID      uuid.UUID
+	Spec        task.Task
+	SubmittedAt time.Time
+}
+
+// RecordTaskSpec adds a submitted task to the spec history of its name
+func (m *Manager) RecordTaskSpec(t task.Task) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	versions := m.TaskSpecs[t.Name]
+	m.TaskSpecs[t.Name] = append(versions, TaskSpecVersion{
+		Version:     len(versions) + 1,
+		TaskID:      t.ID,
+		Spec:        t.Spec(),
+		SubmittedAt: time.Now().UTC(),
+	})
+}
+
+// GetTaskSpecs returns the specs submitted under a task name, oldest first
+func (m *Manager) GetTaskSpecs(name string) ([]TaskSpecVersion, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	versions, ok := m.TaskSpecs[name]
+	return slices.Clone(versions), ok
+}
+
+// StopTask queues an event stopping a known task
+func (m *Manager) StopTask(id uuid.UUID, opts *task.StopOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stopTask(id, opts)
+}
+
+func (m *Manager) stopTask(id uuid.UUID, opts *task.StopOptions) error {
+	t, ok := m.TaskDb[id]
+	if !ok {
+		// not dispatched yet, a completed entry makes assign drop its start
+		queued, found := m.queuedStart(id)
+		if !found {
+			return fmt.Errorf("no task with id %v", id)
+		}
+		queued.State = task.Completed
+		queued.FinishTime = time.Now().UTC()
+		queued.FinishReason = task.ReasonStopped
+		m.TaskDb[id] = &queued
+		log.Printf("Cancelled the queued start of task %v \n", id)
+		return nil
+	}
+
+	taskCopy := *t
+	taskCopy.State = task.Completed
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Completed,
+		TimeStamp: time.Now(),
+		Task:      taskCopy,
+		Stop:      opts,
+	}
+	m.addTask(te)
+
+	log.Printf("Added task event %v to stop %v \n", te.ID, id)
+	return nil
+}
+
+// queuedStart returns the task of a start event still waiting in the pending queue
+func (m *Manager) queuedStart(id uuid.UUID) (task.Task, bool) {
+	var found task.Task
+	ok := false
+	// walk the whole queue so it keeps its order
+	for n := m.Pending.Len(); n > 0; n-- {
+		te := m.Pending.Dequeue().(task.TaskEvent)
+		if te.State != task.Completed && te.Task.ID == id {
+			found, ok = te.Task, true
+		}
+		m.Pending.Enqueue(te)
+	}
+	return found, ok
+}
+
+// workerClient asks workers for data loops wait on, so a hung worker can't stall them
+var workerClient = &http.Client{Timeout: 10 * time.Second}
+
 func New(workers []string) *Manager {
 	taskDb := make(map[uuid.UUID]*task.Task)
 	eventDb := make(map[uuid.UUID]*task.TaskEvent)
@@ -170,6 +461,12 @@ func New(workers []string) *Manager {
 
 	}
 
+	// an in memory store with a random key, main replaces it when a key is configured
+	secrets, err := NewSecretStore("", "")
+	if err != nil {
+		log.Fatalf("Error creating secret store: %v", err)
+	}
+
 	return &Manager{
 		Pending:       *queue.New(),
 		Workers:       workers,
@@ -177,15 +474,183 @@ func New(workers []string) *Manager {
 		EventDb:       eventDb,
 		WorkerTaskMap: workerTaskMap,
 		TaskWorkerMap: taskWorkerMap,
+		WorkerDrivers: make(map[string][]string),
+		Registries:    make(map[string]task.RegistryAuth),
+		Secrets:       secrets,
+		Services:      make(map[string]*Service),
+		Jobs:          make(map[uuid.UUID]*Job),
+		Policies:      make(map[string]SecurityPolicy),
+		retries:       make(map[uuid.UUID]*retry),
+		starting:      make(map[uuid.UUID]task.TaskEvent),
+		LastSeen:      make(map[uuid.UUID]time.Time),
+		TaskSpecs:     make(map[string][]TaskSpecVersion),
 	}
 
 }
 
-func (m *Manager) GetTasks() []*task.Task {
-	tasks := []*task.Task{}
+// workerOf returns the worker a task was assigned to
+func (m *Manager) workerOf(id uuid.UUID) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.TaskWorkerMap[id]
+	return w, ok
+}
+
+// TaskListing is a task as shown by the manager API
+type TaskListing struct {
+	*task.Task
+	RemainingRuntime *int `json:",omitempty"` //seconds left before a running task hits its MaxRuntime
+}
+
+func (m *Manager) GetTasks() []TaskListing {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tasks := []TaskListing{}
 	for _, t := range m.TaskDb {
-		tasks = append(tasks, t)
+		tc := *t
+		listing := TaskListing{Task: &tc}
+		deadline, ok := t.Deadline()
+		if ok && t.State == task.Running {
+			remaining := max(int(time.Until(deadline).Seconds()), 0)
+			listing.RemainingRuntime = &remaining
+		}
+		tasks = append(tasks, listing)
 	}
 
 	return tasks
 }
+
+// TaskUsage sums up the usage samples a worker collected for one task
+type TaskUsage struct {
+	TaskID          uuid.UUID
+	Samples         int
+	CpuPercentAvg   float64
+	CpuPercentMax   float64
+	MemoryAvg       uint64
+	MemoryMax       uint64
+	MemoryRequested int //what the task asked for, to compare against MemoryMax
+	Latest          *task.UsageSample
+	History         []task.UsageSample
+}
+
+func summarizeUsage(t *task.Task, samples []task.UsageSample) TaskUsage {
+	u := TaskUsage{
+		TaskID:          t.ID,
+		Samples:         len(samples),
+		MemoryRequested: t.Memory,
+		History:         samples,
+	}
+	if len(samples) == 0 {
+		return u
+	}
+
+	var cpuTotal float64
+	var memTotal uint64
+	for _, s := range samples {
+		cpuTotal += s.CpuPercent
+		memTotal += s.MemoryUsage
+		u.CpuPercentMax = max(u.CpuPercentMax, s.CpuPercent)
+		u.MemoryMax = max(u.MemoryMax, s.MemoryUsage)
+	}
+	u.CpuPercentAvg = cpuTotal / float64(len(samples))
+	u.MemoryAvg = memTotal / uint64(len(samples))
+	u.Latest = &samples[len(samples)-1]
+
+	return u
+}
+
+// GetTaskUsage fetches a task's usage samples from its worker and summarizes them
+func (m *Manager) GetTaskUsage(id uuid.UUID) (TaskUsage, error) {
+	m.mu.Lock()
+	t, ok := m.TaskDb[id]
+	if !ok {
+		m.mu.Unlock()
+		return TaskUsage{}, fmt.Errorf("no task with id %v", id)
+	}
+	tc := *t
+	w, ok := m.TaskWorkerMap[id]
+	m.mu.Unlock()
+	if !ok {
+		return TaskUsage{}, fmt.Errorf("task %v is not assigned to a worker", id)
+	}
+
+	url := fmt.Sprintf("http://%s/tasks/%s/stats", w, id)
+	resp, err := workerClient.Get(url)
+	if err != nil {
+		return TaskUsage{}, fmt.Errorf("error connecting to worker %s: %v", w, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := worker.ErrResponse{}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return TaskUsage{}, fmt.Errorf("worker %s: %s", w, e.Message)
+	}
+
+	var samples []task.UsageSample
+	err = json.NewDecoder(resp.Body).Decode(&samples)
+	if err != nil {
+		return TaskUsage{}, fmt.Errorf("error decoding stats from worker %s: %v", w, err)
+	}
+
+	return summarizeUsage(&tc, samples), nil
+}
+
+func (m *Manager) SetRegistryAuth(host string, auth task.RegistryAuth) {
+	if auth.ServerAddress == "" {
+		auth.ServerAddress = host
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Registries[host] = auth
+}
+
+func (m *Manager) RemoveRegistryAuth(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.Registries, host)
+}
+
+// GetRegistries returns the hosts credentials are stored for, never the credentials themselves
+func (m *Manager) GetRegistries() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hosts := []string{}
+	for host := range m.Registries {
+		hosts = append(hosts, host)
+	}
+
+	return hosts
+}
+
+// registryAuthFor returns the credentials for the registry of an image, called with m.mu held
+func (m *Manager) registryAuthFor(image string) *task.RegistryAuth {
+	auth, ok := m.Registries[task.RegistryHost(image)]
+	if !ok {
+		return nil
+	}
+
+	return &auth
+}
+
+// secretsFor resolves the values of the secrets a task references
+func (m *Manager) secretsFor(t task.Task) (map[string]string, error) {
+	if len(t.Secrets) == 0 {
+		return nil, nil
+	}
+
+	values := make(map[string]string)
+	for _, ref := range t.Secrets {
+		if (ref.Env == "") == (ref.File == "") {
+			return nil, fmt.Errorf("secret %s needs exactly one of Env and File", ref.Name)
+		}
+		v, err := m.Secrets.Get(ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		values[ref.Name] = v
+	}
+
+	return values, nil
+}
@@ -0,0 +1,296 @@
+package manager
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/arhantbararia/goat/task"
+	"github.com/arhantbararia/goat/worker"
+	"github.com/golang-collections/collections/queue"
+	"github.com/google/uuid"
+)
+
+func TestMain(m *testing.M) {
+	// the workers started by the tests pick up their work quickly
+	worker.WORKER_SLEEP_TIME = 1
+	worker.WORKER_INSPECT_TIME = 1
+	os.Exit(m.Run())
+}
+
+// startWorker runs a worker backed by the fake driver and returns its address
+func startWorker(t *testing.T, opts task.FakeOptions) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	opts.Rand = rand.New(rand.NewSource(1))
+	w := &worker.Worker{
+		Name:    "test",
+		Queue:   *queue.New(),
+		Db:      make(map[uuid.UUID]*task.Task),
+		Drivers: map[string]task.Driver{task.DriverDocker: task.NewFake(opts)},
+	}
+	api := worker.API{Address: "127.0.0.1", Port: port, Worker: w}
+	go api.Start()
+	go w.RunTasks()
+	go w.UpdateTasks()
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	for i := 0; i < 100; i++ {
+		resp, err := http.Get("http://" + addr + "/drivers")
+		if err == nil {
+			resp.Body.Close()
+			return addr
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("worker on %s never came up", addr)
+	return ""
+}
+
+// waitForState polls the workers until the task reaches the state
+func waitForState(t *testing.T, m *Manager, id uuid.UUID, want task.State) {
+	t.Helper()
+	var got task.State
+	for i := 0; i < 50; i++ {
+		m.updateTasks()
+		m.mu.Lock()
+		got = m.TaskDb[id].State
+		m.mu.Unlock()
+		if got == want {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("task %v is in state %v, want %v", id, got, want)
+}
+
+func startEvent(t task.Task) task.TaskEvent {
+	t.State = task.Scheduled
+	return task.TaskEvent{ID: uuid.New(), State: task.Running, TimeStamp: time.Now(), Task: t}
+}
+
+func TestSendWorkStartsAndStopsTask(t *testing.T) {
+	m := New([]string{startWorker(t, task.FakeOptions{})})
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "img"}
+
+	m.AddTask(startEvent(tk))
+	m.SendWork()
+	waitForState(t, m, tk.ID, task.Running)
+
+	err := m.StopTask(tk.ID, nil)
+	if err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	m.SendWork()
+	waitForState(t, m, tk.ID, task.Completed)
+}
+
+func TestCrashedTaskFails(t *testing.T) {
+	addr := startWorker(t, task.FakeOptions{CrashRate: 1, CrashAfter: 100 * time.Millisecond})
+	m := New([]string{addr})
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "img"}
+
+	m.AddTask(startEvent(tk))
+	m.SendWork()
+	waitForState(t, m, tk.ID, task.Failed)
+}
+
+func TestDispatchGivesUpOnUnreachableWorker(t *testing.T) {
+	attempts := MaxDispatchAttempts
+	MaxDispatchAttempts = 1
+	defer func() { MaxDispatchAttempts = attempts }()
+
+	// nothing listens on the port once the listener is closed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	m := New([]string{l.Addr().String()})
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "img"}
+
+	m.AddTask(startEvent(tk))
+	m.SendWork()
+
+	if letters := m.GetDeadLetters(); len(letters) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(letters))
+	}
+	if got := m.TaskDb[tk.ID]; got.State != task.Failed || got.FinishReason != task.ReasonStartFailed {
+		t.Fatalf("got state %v finished for %q, want a failed start", got.State, got.FinishReason)
+	}
+}
+
+func TestFailedStartIsRetriedOnAnotherWorker(t *testing.T) {
+	delay := dispatchBackoff
+	dispatchBackoff = 0
+	defer func() { dispatchBackoff = delay }()
+
+	good := startWorker(t, task.FakeOptions{})
+	bad := startWorker(t, task.FakeOptions{FailStartRate: 1})
+	// round robin picks the second worker first
+	m := New([]string{good, bad})
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "img"}
+
+	m.AddTask(startEvent(tk))
+	for i := 0; i < 50; i++ {
+		m.SendWork()
+		m.updateTasks()
+		if m.TaskDb[tk.ID].State == task.Running {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if got := m.TaskDb[tk.ID]; got.State != task.Running || m.TaskWorkerMap[tk.ID] != good {
+		t.Fatalf("task is %v on %s, want it running on %s", got.State, m.TaskWorkerMap[tk.ID], good)
+	}
+	if len(m.retries) != 0 || len(m.starting) != 0 {
+		t.Errorf("the started task left %d retries and %d starts behind", len(m.retries), len(m.starting))
+	}
+}
+
+func TestStopOfUnassignedTaskCompletesLocally(t *testing.T) {
+	m := New(nil)
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "img", State: task.Pending}
+	m.TaskDb[tk.ID] = &tk
+
+	err := m.StopTask(tk.ID, nil)
+	if err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	m.SendWork()
+
+	got := m.TaskDb[tk.ID]
+	if got.State != task.Completed || got.FinishReason != task.ReasonStopped {
+		t.Fatalf("got state %v finished for %q, want a stopped task", got.State, got.FinishReason)
+	}
+}
+
+func TestStopTaskWhoseSecretWasRemoved(t *testing.T) {
+	m := New([]string{startWorker(t, task.FakeOptions{})})
+	m.Secrets.Set("pw", "hunter2")
+	tk := task.Task{ID: uuid.New(), Name: "db", Image: "img", Secrets: []task.SecretRef{{Name: "pw", Env: "PASSWORD"}}}
+
+	m.AddTask(startEvent(tk))
+	m.SendWork()
+	waitForState(t, m, tk.ID, task.Running)
+
+	m.Secrets.Remove("pw")
+	err := m.StopTask(tk.ID, nil)
+	if err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	m.SendWork()
+	waitForState(t, m, tk.ID, task.Completed)
+}
+
+// drain has the manager hand out every queued event
+func drain(m *Manager) {
+	for m.Pending.Len() > 0 {
+		m.SendWork()
+	}
+}
+
+// settle runs the reconcile loops against the workers until done reports true
+func settle(t *testing.T, m *Manager, done func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		m.reconcileServices()
+		m.reconcileJobs()
+		drain(m)
+		m.updateTasks()
+		if done() {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("manager never settled")
+}
+
+func TestRemoveServiceCancelsQueuedStarts(t *testing.T) {
+	m := New(nil)
+	err := m.AddService(Service{Name: "web", Replicas: 2, Template: task.Task{Image: "img"}})
+	if err != nil {
+		t.Fatalf("add service: %v", err)
+	}
+	m.reconcileServices()
+	ids := slices.Clone(m.Services["web"].Tasks)
+
+	err = m.RemoveService("web")
+	if err != nil {
+		t.Fatalf("remove service: %v", err)
+	}
+	drain(m)
+
+	for _, id := range ids {
+		if got := m.TaskDb[id]; got == nil || got.State != task.Completed {
+			t.Errorf("task %v of the removed service is %+v, want its start cancelled", id, got)
+		}
+		if w, ok := m.TaskWorkerMap[id]; ok {
+			t.Errorf("task %v of the removed service was sent to %s", id, w)
+		}
+	}
+}
+
+func TestStopJobCancelsQueuedStarts(t *testing.T) {
+	m := New(nil)
+	status, err := m.AddJob(Job{Count: 3, Template: task.Task{Image: "img"}})
+	if err != nil {
+		t.Fatalf("add job: %v", err)
+	}
+	m.reconcileJobs()
+	ids := []uuid.UUID{}
+	for _, idx := range m.Jobs[status.ID].Indexes {
+		ids = append(ids, idx.TaskID)
+	}
+
+	err = m.StopJob(status.ID)
+	if err != nil {
+		t.Fatalf("stop job: %v", err)
+	}
+	drain(m)
+
+	for _, id := range ids {
+		if w, ok := m.TaskWorkerMap[id]; ok {
+			t.Errorf("task %v of the stopped job was sent to %s", id, w)
+		}
+	}
+	if got, _ := m.GetJob(status.ID); got.Pending != 3 || got.Running != 0 {
+		t.Errorf("stopped job has %d pending and %d running indexes, want 3 and 0", got.Pending, got.Running)
+	}
+}
+
+func TestTaskNeverStartedByItsWorkerIsReplaced(t *testing.T) {
+	m := New(nil)
+	err := m.AddService(Service{Name: "web", Replicas: 1, Template: task.Task{Image: "img"}})
+	if err != nil {
+		t.Fatalf("add service: %v", err)
+	}
+	m.reconcileServices()
+	id := m.Services["web"].Tasks[0]
+
+	// a worker took the task and went away before reporting it
+	te, _ := m.Pending.Dequeue().(task.TaskEvent)
+	m.TaskDb[id] = &te.Task
+	m.TaskWorkerMap[id] = "gone:8000"
+	m.LastSeen[id] = time.Now().Add(-2 * lostTimeout)
+	m.reconcileServices()
+
+	if got := m.TaskDb[id]; got.State != task.Failed || got.FinishReason != task.ReasonLost {
+		t.Errorf("task stuck in %v (%s), want it failed as lost", got.State, got.FinishReason)
+	}
+	if tasks := m.Services["web"].Tasks; len(tasks) != 1 || tasks[0] == id {
+		t.Errorf("service tasks are %v, want one replacement", tasks)
+	}
+}
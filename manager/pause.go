@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/arhantbararia/goat/task"
+	"github.com/arhantbararia/goat/worker"
+	"github.com/google/uuid"
+)
+
+var ErrTaskNotFound = errors.New("task not found")
+
+// WorkerError is an error answer of a worker, with the status it answered with
+type WorkerError struct {
+	Worker     string
+	StatusCode int
+	Message    string
+}
+
+func (e WorkerError) Error() string {
+	return fmt.Sprintf("worker %s: %s", e.Worker, e.Message)
+}
+
+// PauseTask freezes a running task on its worker
+func (m *Manager) PauseTask(id uuid.UUID) (task.Task, error) {
+	return m.taskAction(id, "pause", task.Paused)
+}
+
+// ResumeTask thaws a paused task on its worker
+func (m *Manager) ResumeTask(id uuid.UUID) (task.Task, error) {
+	return m.taskAction(id, "resume", task.Running)
+}
+
+// RestartTask restarts a running or paused task in place on its worker
+func (m *Manager) RestartTask(id uuid.UUID) (task.Task, error) {
+	return m.taskAction(id, "restart", task.Running)
+}
+
+// taskAction asks the worker of a task to pause, resume or restart it, then
+// records the outcome as an event of the task
+func (m *Manager) taskAction(id uuid.UUID, action string, state task.State) (task.Task, error) {
+	m.mu.Lock()
+	_, known := m.TaskDb[id]
+	w, assigned := m.TaskWorkerMap[id]
+	m.mu.Unlock()
+	if !known {
+		return task.Task{}, fmt.Errorf("%w: %v", ErrTaskNotFound, id)
+	}
+	if !assigned {
+		return task.Task{}, fmt.Errorf("%w: task %v is not assigned to a worker", ErrTaskNotFound, id)
+	}
+
+	url := fmt.Sprintf("http://%s/tasks/%s/%s", w, id, action)
+	resp, err := http.Post(url, "application/json", nil)
+	if err != nil {
+		return task.Task{}, fmt.Errorf("error connecting to worker %s: %v", w, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := worker.ErrResponse{}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return task.Task{}, WorkerError{Worker: w, StatusCode: resp.StatusCode, Message: e.Message}
+	}
+
+	updated := task.Task{}
+	err = json.NewDecoder(resp.Body).Decode(&updated)
+	if err != nil {
+		return task.Task{}, fmt.Errorf("error decoding task from worker %s: %v", w, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.TaskDb[id]
+	if !ok {
+		return task.Task{}, fmt.Errorf("%w: %v", ErrTaskNotFound, id)
+	}
+	t.State = state
+	t.ContainerID = updated.ContainerID
+	t.StartTime = updated.StartTime
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     state,
+		TimeStamp: time.Now().UTC(),
+		Task:      *t,
+	}
+	m.EventDb[te.ID] = &te
+	log.Printf("Added task event %v to record %s of %v on worker %s \n", te.ID, action, id, w)
+
+	return *t, nil
+}
@@ -0,0 +1,165 @@
+package manager
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/arhantbararia/goat/task"
+)
+
+// SecurityPolicy limits the security settings, drivers and host paths tasks
+// of a namespace may use. Namespaces without a policy may use any of them.
+type SecurityPolicy struct {
+	AllowedCapabilities    []string //capabilities tasks may add, none when empty
+	AllowedDrivers         []string //drivers besides docker tasks may use, e.g. exec, none when empty
+	AllowedBindSources     []string //host paths tasks may bind mount, with what is below them, none when empty
+	RequireNonRoot         bool
+	RequireReadOnlyRootfs  bool
+	RequireNoNewPrivileges bool
+	ForbidUnconfined       bool  //rejects unconfined seccomp and AppArmor profiles
+	MaxPidsLimit           int64 //when set, tasks need a PidsLimit of at most this
+}
+
+// PolicyError lists the settings of a task spec the policy of its namespace forbids
+type PolicyError struct {
+	Namespace string
+	Errors    task.ValidationError
+}
+
+func (e PolicyError) Error() string {
+	msgs := []string{}
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return fmt.Sprintf("forbidden by the security policy of namespace %s: %s", e.Namespace, strings.Join(msgs, "; "))
+}
+
+func (p SecurityPolicy) validate() task.ValidationError {
+	v := task.ValidationError{}
+	if p.MaxPidsLimit < 0 {
+		v = append(v, task.FieldError{Field: "MaxPidsLimit", Message: "must not be negative"})
+	}
+	for i, src := range p.AllowedBindSources {
+		if !path.IsAbs(src) {
+			v = append(v, task.FieldError{Field: fmt.Sprintf("AllowedBindSources[%d]", i), Message: "must be an absolute path"})
+		}
+	}
+	return v
+}
+
+// bindAllowed reports a host path that is one of the allowed bind sources or below one
+func (p SecurityPolicy) bindAllowed(src string) bool {
+	src = path.Clean(src)
+	for _, allowed := range p.AllowedBindSources {
+		allowed = path.Clean(allowed)
+		if src == allowed || allowed == "/" || strings.HasPrefix(src, allowed+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (p SecurityPolicy) checkMounts(v *task.ValidationError, field string, mounts []task.Mount) {
+	for i, m := range mounts {
+		if m.Type == task.MountBind && !p.bindAllowed(m.Source) {
+			*v = append(*v, task.FieldError{Field: fmt.Sprintf("%s[%d].Source", field, i), Message: fmt.Sprintf("bind mounting %s is not allowed", m.Source)})
+		}
+	}
+}
+
+// capability names are compared without case and CAP_ prefix
+func capabilityName(c string) string {
+	return strings.TrimPrefix(strings.ToUpper(c), "CAP_")
+}
+
+func (p SecurityPolicy) check(t task.Task) task.ValidationError {
+	v := task.ValidationError{}
+	s := t.Security
+	if s == nil {
+		s = &task.Security{}
+	}
+
+	// a host process or a host path is past anything the settings below restrict
+	if driver := task.DriverName(t); driver != task.DriverDocker && !slices.Contains(p.AllowedDrivers, driver) {
+		v = append(v, task.FieldError{Field: "Driver", Message: fmt.Sprintf("driver %s is not allowed", driver)})
+	}
+	p.checkMounts(&v, "Mounts", t.Mounts)
+	for i, c := range t.InitContainers {
+		p.checkMounts(&v, fmt.Sprintf("InitContainers[%d].Mounts", i), c.Mounts)
+	}
+	for i, c := range t.Sidecars {
+		p.checkMounts(&v, fmt.Sprintf("Sidecars[%d].Mounts", i), c.Mounts)
+	}
+
+	allowed := []string{}
+	for _, c := range p.AllowedCapabilities {
+		allowed = append(allowed, capabilityName(c))
+	}
+	for i, c := range s.CapAdd {
+		if !slices.Contains(allowed, capabilityName(c)) {
+			v = append(v, task.FieldError{Field: fmt.Sprintf("Security.CapAdd[%d]", i), Message: fmt.Sprintf("capability %s is not allowed", c)})
+		}
+	}
+	if p.RequireNonRoot && s.RunsAsRoot() {
+		v = append(v, task.FieldError{Field: "Security.User", Message: "must be set to a user other than root"})
+	}
+	if p.RequireReadOnlyRootfs && !s.ReadOnlyRootfs {
+		v = append(v, task.FieldError{Field: "Security.ReadOnlyRootfs", Message: "is required"})
+	}
+	if p.RequireNoNewPrivileges && !s.NoNewPrivileges {
+		v = append(v, task.FieldError{Field: "Security.NoNewPrivileges", Message: "is required"})
+	}
+	if p.ForbidUnconfined {
+		if s.SeccompProfile == task.ProfileUnconfined {
+			v = append(v, task.FieldError{Field: "Security.SeccompProfile", Message: "must not be unconfined"})
+		}
+		if s.AppArmorProfile == task.ProfileUnconfined {
+			v = append(v, task.FieldError{Field: "Security.AppArmorProfile", Message: "must not be unconfined"})
+		}
+	}
+	if p.MaxPidsLimit > 0 && (s.PidsLimit == 0 || s.PidsLimit > p.MaxPidsLimit) {
+		v = append(v, task.FieldError{Field: "Security.PidsLimit", Message: fmt.Sprintf("must be between 1 and %d", p.MaxPidsLimit)})
+	}
+	return v
+}
+
+// CheckPolicy returns a PolicyError when the task breaks the security policy of its namespace
+func (m *Manager) CheckPolicy(t task.Task) error {
+	namespace := task.NamespaceName(t)
+	m.policiesMu.Lock()
+	p, ok := m.Policies[namespace]
+	m.policiesMu.Unlock()
+	if !ok {
+		return nil
+	}
+	if v := p.check(t); len(v) > 0 {
+		return PolicyError{Namespace: namespace, Errors: v}
+	}
+	return nil
+}
+
+// SetPolicy sets the security policy of a namespace. Tasks already running keep running.
+func (m *Manager) SetPolicy(namespace string, p SecurityPolicy) error {
+	if v := p.validate(); len(v) > 0 {
+		return v
+	}
+	m.policiesMu.Lock()
+	defer m.policiesMu.Unlock()
+	m.Policies[namespace] = p
+	return nil
+}
+
+func (m *Manager) RemovePolicy(namespace string) {
+	m.policiesMu.Lock()
+	defer m.policiesMu.Unlock()
+	delete(m.Policies, namespace)
+}
+
+func (m *Manager) GetPolicies() map[string]SecurityPolicy {
+	m.policiesMu.Lock()
+	defer m.policiesMu.Unlock()
+	return maps.Clone(m.Policies)
+}
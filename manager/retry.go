@@ -0,0 +1,145 @@
+package manager
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/arhantbararia/goat/task"
+	"github.com/google/uuid"
+)
+
+// how often the manager tries to hand an event to a worker before giving up on it
+var MaxDispatchAttempts = 5
+
+// wait before the first retry of a dispatch, doubled on every further one up to maxDispatchBackoff
+var (
+	dispatchBackoff    = 10 * time.Second
+	maxDispatchBackoff = 5 * time.Minute
+)
+
+// DispatchFailure is one failed attempt to hand an event to a worker
+type DispatchFailure struct {
+	Worker string
+	Error  string
+	Time   time.Time
+}
+
+// DeadLetter is an event the manager gave up dispatching
+type DeadLetter struct {
+	Event    task.TaskEvent
+	Failures []DispatchFailure
+	DeadAt   time.Time
+}
+
+type retry struct {
+	failures []DispatchFailure
+	retryAt  time.Time
+}
+
+// retryableStatus reports worker answers that may change on a later attempt:
+// server errors, and a worker that is busy or in the middle of something
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return code >= 500
+}
+
+func backoff(attempt int) time.Duration {
+	d := dispatchBackoff
+	for i := 1; i < attempt && d < maxDispatchBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxDispatchBackoff)
+}
+
+// nextEvent dequeues the first event that is not waiting out a retry backoff,
+// the ones it passes over go back to the queue in the same order
+func (m *Manager) nextEvent() (task.TaskEvent, bool) {
+	for n := m.Pending.Len(); n > 0; n-- {
+		te := m.Pending.Dequeue().(task.TaskEvent)
+		if r, ok := m.retries[te.ID]; ok && time.Now().Before(r.retryAt) {
+			m.Pending.Enqueue(te)
+			continue
+		}
+		return te, true
+	}
+	return task.TaskEvent{}, false
+}
+
+// lastFailedWorker returns the worker the last dispatch of an event failed on, if any
+func (m *Manager) lastFailedWorker(id uuid.UUID) string {
+	r, ok := m.retries[id]
+	if !ok || len(r.failures) == 0 {
+		return ""
+	}
+	return r.failures[len(r.failures)-1].Worker
+}
+
+// started forgets the start of a task that is no longer waiting on its worker
+func (m *Manager) started(id uuid.UUID) {
+	if te, ok := m.starting[id]; ok {
+		delete(m.retries, te.ID)
+		delete(m.starting, id)
+	}
+}
+
+// dispatchFailed records a failed dispatch. The event is queued again after a
+// backoff while it has attempts left and the failure is worth retrying,
+// otherwise it goes to the dead letters and a task it was starting fails.
+func (m *Manager) dispatchFailed(te task.TaskEvent, w string, err error, retryable bool) {
+	t := te.Task
+	log.Printf("Error dispatching task %v to worker %s: %v \n", t.ID, w, err)
+
+	r, ok := m.retries[te.ID]
+	if !ok {
+		r = &retry{}
+		m.retries[te.ID] = r
+	}
+	r.failures = append(r.failures, DispatchFailure{Worker: w, Error: err.Error(), Time: time.Now().UTC()})
+
+	stop := te.State == task.Completed
+	if !stop {
+		// the next attempt may pick another worker
+		m.WorkerTaskMap[w] = slices.DeleteFunc(m.WorkerTaskMap[w], func(id uuid.UUID) bool { return id == t.ID })
+		delete(m.TaskWorkerMap, t.ID)
+		delete(m.LastSeen, t.ID)
+	}
+
+	attempts := len(r.failures)
+	if retryable && attempts < MaxDispatchAttempts {
+		r.retryAt = time.Now().Add(backoff(attempts))
+		log.Printf("Retrying task %v in %v, attempt %d of %d \n", t.ID, backoff(attempts), attempts+1, MaxDispatchAttempts)
+		m.Pending.Enqueue(te)
+		return
+	}
+
+	delete(m.retries, te.ID)
+	m.DeadLetters = append(m.DeadLetters, DeadLetter{
+		Event:    te,
+		Failures: r.failures,
+		DeadAt:   time.Now().UTC(),
+	})
+	log.Printf("Giving up on task event %v after %d attempts \n", te.ID, attempts)
+
+	if !stop {
+		t.State = task.Failed
+		t.RecordStartFailure(fmt.Errorf("not dispatched after %d attempts: %v", attempts, err))
+		m.TaskDb[t.ID] = &t
+	}
+}
+
+// GetDeadLetters returns the events the manager gave up dispatching, oldest first
+func (m *Manager) GetDeadLetters() []DeadLetter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	letters := slices.Clone(m.DeadLetters)
+	if letters == nil {
+		letters = []DeadLetter{}
+	}
+	return letters
+}
@@ -0,0 +1,453 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/arhantbararia/goat/task"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNoRollout      = errors.New("service has no rollout in progress")
+	ErrUnknownVersion = errors.New("unknown service version")
+)
+
+// states of a rollout
+const (
+	RolloutRunning   = "RollingOut"
+	RolloutPaused    = "Paused"
+	RolloutCompleted = "Completed"
+	RolloutAborted   = "Aborted"
+)
+
+// deployment strategies
+const (
+	StrategyRolling   = "rolling"   //replace tasks a few at a time
+	StrategyCanary    = "canary"    //run a few new tasks next to the old ones, then promote or abort
+	StrategyBlueGreen = "bluegreen" //bring up a full new set before tearing down the old one
+)
+
+// phases of a canary rollout
+const (
+	PhaseCanary   = "Canary"   //canaries run next to the old tasks while they are analysed
+	PhasePromoted = "Promoted" //the canaries passed, the rest is rolled out
+)
+
+// UpdateConfig controls how a service moves its tasks to a new template
+type UpdateConfig struct {
+	MaxSurge       int //tasks started on top of Replicas while rolling out
+	MaxUnavailable int //tasks below Replicas the service may drop to while rolling out
+	MinReady       int //seconds a new task has to keep running before it counts as healthy, 10 when 0
+	Strategy       string
+	CanaryReplicas int     //new tasks a canary runs next to the old ones, 1 when 0
+	AnalysisPeriod int     //seconds the canaries run before they are judged, 60 when 0
+	MaxFailureRate float64 //share of the canaries started that may fail, 0 to 1
+}
+
+// Rollout tracks the move of a service from one template version to the next
+type Rollout struct {
+	FromVersion    int
+	ToVersion      int
+	Strategy       string
+	Phase          string `json:",omitempty"`
+	State          string
+	Message        string //why the rollout paused or aborted
+	Updated        int    //healthy tasks running the new version
+	Outdated       int    //live tasks still running an older version
+	Canaries       int    `json:",omitempty"` //canary tasks started so far, replacements included
+	CanaryFailures int    `json:",omitempty"`
+	AnalysisEndsAt time.Time
+	StartedAt      time.Time
+	FinishedAt     time.Time
+}
+
+// a new task has to run this long before it counts as healthy, unless MinReady says otherwise
+const defaultMinReady = 10
+
+// canaries are judged after this many seconds, unless AnalysisPeriod says otherwise
+const defaultAnalysisPeriod = 60
+
+func (u UpdateConfig) validate() task.ValidationError {
+	v := task.ValidationError{}
+	if u.MaxSurge < 0 {
+		v = append(v, task.FieldError{Field: "Update.MaxSurge", Message: "must not be negative"})
+	}
+	if u.MaxUnavailable < 0 {
+		v = append(v, task.FieldError{Field: "Update.MaxUnavailable", Message: "must not be negative"})
+	}
+	if u.MinReady < 0 {
+		v = append(v, task.FieldError{Field: "Update.MinReady", Message: "must not be negative"})
+	}
+	switch u.Strategy {
+	case "", StrategyRolling, StrategyCanary, StrategyBlueGreen:
+	default:
+		v = append(v, task.FieldError{Field: "Update.Strategy", Message: fmt.Sprintf("unknown strategy %q", u.Strategy)})
+	}
+	if u.CanaryReplicas < 0 {
+		v = append(v, task.FieldError{Field: "Update.CanaryReplicas", Message: "must not be negative"})
+	}
+	if u.AnalysisPeriod < 0 {
+		v = append(v, task.FieldError{Field: "Update.AnalysisPeriod", Message: "must not be negative"})
+	}
+	if u.MaxFailureRate < 0 || u.MaxFailureRate > 1 {
+		v = append(v, task.FieldError{Field: "Update.MaxFailureRate", Message: "must be between 0 and 1"})
+	}
+	return v
+}
+
+func (u UpdateConfig) strategy() string {
+	if u.Strategy == "" {
+		return StrategyRolling
+	}
+	return u.Strategy
+}
+
+func (u UpdateConfig) canaries() int {
+	if u.CanaryReplicas == 0 {
+		return 1
+	}
+	return u.CanaryReplicas
+}
+
+func (u UpdateConfig) analysisPeriod() time.Duration {
+	if u.AnalysisPeriod == 0 {
+		return defaultAnalysisPeriod * time.Second
+	}
+	return time.Duration(u.AnalysisPeriod) * time.Second
+}
+
+// active reports a rollout that is still running or paused
+func (r *Rollout) active() bool {
+	return r != nil && (r.State == RolloutRunning || r.State == RolloutPaused)
+}
+
+// surge returns MaxSurge, at least one task when neither surge nor unavailability is allowed
+func (u UpdateConfig) surge() int {
+	if u.MaxSurge == 0 && u.MaxUnavailable == 0 {
+		return 1
+	}
+	return u.MaxSurge
+}
+
+func (u UpdateConfig) minReady() time.Duration {
+	if u.MinReady == 0 {
+		return defaultMinReady * time.Second
+	}
+	return time.Duration(u.MinReady) * time.Second
+}
+
+// UpdateService rolls a service out to a new template
+func (m *Manager) UpdateService(name string, template task.Task, update *UpdateConfig) error {
+	err := m.CheckPolicy(template)
+	if err != nil {
+		return err
+	}
+
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+
+	s, ok := m.Services[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+
+	return s.rollTo(template, update, "")
+}
+
+// RollbackService rolls a service out to the template of an earlier version, the previous one when version is 0
+func (m *Manager) RollbackService(name string, version int) error {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+
+	s, ok := m.Services[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+
+	if version == 0 {
+		version = s.Version - 1
+	}
+	if version == s.Version {
+		return fmt.Errorf("%w: %s is already at version %d", ErrUnknownVersion, name, version)
+	}
+	v, ok := s.version(version)
+	if !ok {
+		return fmt.Errorf("%w: %s has no version %d", ErrUnknownVersion, name, version)
+	}
+	return s.rollTo(v.Template, &v.Update, fmt.Sprintf("rollback to version %d", version))
+}
+
+// version looks up a version in the history of the service
+func (s *Service) version(version int) (ServiceVersion, bool) {
+	for _, v := range s.History {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return ServiceVersion{}, false
+}
+
+// rollTo starts a rollout of the service to a new version built from template
+func (s *Service) rollTo(template task.Task, update *UpdateConfig, note string) error {
+	updated := *s
+	updated.Template = template.Spec()
+	if update != nil {
+		updated.Update = *update
+	}
+	err := updated.validate()
+	if err != nil {
+		return err
+	}
+
+	previous := s.Template
+	s.PreviousTemplate = &previous
+	s.Template = updated.Template
+	s.Update = updated.Update
+	// versions come from the history, an aborted rollout leaves the service behind its latest one
+	next := s.History[len(s.History)-1].Version + 1
+	s.Rollout = &Rollout{
+		FromVersion: s.Version,
+		ToVersion:   next,
+		Strategy:    s.Update.strategy(),
+		State:       RolloutRunning,
+		StartedAt:   time.Now().UTC(),
+	}
+	if s.Rollout.Strategy == StrategyCanary {
+		s.Rollout.Phase = PhaseCanary
+	}
+	s.Version = next
+	s.record(note)
+	log.Printf("Rolling out version %d of service %s with strategy %s \n", s.Version, s.Name, s.Rollout.Strategy)
+
+	return nil
+}
+
+// PauseRollout stops a rollout from replacing more tasks
+func (m *Manager) PauseRollout(name string) error {
+	return m.setRolloutState(name, RolloutPaused, "paused through the API")
+}
+
+// ResumeRollout continues a paused rollout
+func (m *Manager) ResumeRollout(name string) error {
+	return m.setRolloutState(name, RolloutRunning, "")
+}
+
+func (m *Manager) setRolloutState(name string, state string, message string) error {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+
+	s, ok := m.Services[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	if !s.Rollout.active() {
+		return fmt.Errorf("%w: %s", ErrNoRollout, name)
+	}
+	s.Rollout.State = state
+	s.Rollout.Message = message
+
+	return nil
+}
+
+func (m *Manager) GetRollout(name string) (*Rollout, error) {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+
+	s, ok := m.Services[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	if s.Rollout == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoRollout, name)
+	}
+	r := *s.Rollout
+	return &r, nil
+}
+
+// failed handles a failed task of the version being rolled out: failed
+// canaries count towards the failure rate, otherwise the rollout pauses
+func (s *Service) failed(t *task.Task) {
+	r := s.Rollout
+	if !r.active() || t.Version != s.Version || t.State != task.Failed {
+		return
+	}
+	if r.Phase == PhaseCanary {
+		r.CanaryFailures++
+		log.Printf("Canary %v of service %s failed: %s \n", t.ID, s.Name, t.Error)
+		return
+	}
+	if r.State != RolloutRunning {
+		return
+	}
+	r.State = RolloutPaused
+	r.Message = fmt.Sprintf("task %v of version %d failed: %s", t.ID, t.Version, t.Error)
+	log.Printf("Pausing rollout of service %s: %s \n", s.Name, r.Message)
+}
+
+// rollOut moves the live tasks of a service towards its current version
+// following its strategy, and returns the tasks now live
+func (m *Manager) rollOut(s *Service, live []uuid.UUID) []uuid.UUID {
+	r := s.Rollout
+	var current, outdated []uuid.UUID
+	ready, available := 0, 0
+	for _, id := range live {
+		t, ok := m.TaskDb[id]
+		if s.taskVersions[id] == s.Version {
+			current = append(current, id)
+			if ok && m.healthy(t, s.Update) {
+				ready++
+				available++
+			}
+			continue
+		}
+		outdated = append(outdated, id)
+		if ok && t.State == task.Running {
+			available++
+		}
+	}
+	r.Updated = ready
+	r.Outdated = len(outdated)
+
+	// extras left by scaling down mid-rollout are trimmed by reconcile once it completed
+	if len(outdated) == 0 && ready >= s.Replicas && len(current) >= s.Replicas {
+		r.State = RolloutCompleted
+		r.FinishedAt = time.Now().UTC()
+		s.PreviousTemplate = nil
+		log.Printf("Rollout of version %d of service %s completed \n", s.Version, s.Name)
+		return live
+	}
+
+	if r.State == RolloutPaused {
+		// hold the current mix, topping up with the version that was known to work
+		for len(live) < s.Replicas && s.PreviousTemplate != nil {
+			live = append(live, m.startServiceTask(s, *s.PreviousTemplate, r.FromVersion))
+		}
+		return live
+	}
+
+	switch {
+	case r.Strategy == StrategyCanary && r.Phase == PhaseCanary:
+		return m.canary(s, live, current, ready)
+	case r.Strategy == StrategyBlueGreen:
+		return m.blueGreen(s, live, current, outdated, ready)
+	}
+
+	// start new tasks within the surge allowance
+	for len(live) < s.Replicas+s.Update.surge() && len(current) < s.Replicas {
+		id := m.startServiceTask(s, s.Template, s.Version)
+		current = append(current, id)
+		live = append(live, id)
+	}
+
+	// stop outdated tasks as long as enough stay available, ones that are not
+	// running yet, queued ones included, don't count as available so they can always go
+	minAvailable := s.Replicas - s.Update.MaxUnavailable
+	for _, id := range outdated {
+		if t, ok := m.TaskDb[id]; ok && t.State == task.Running {
+			if available <= minAvailable {
+				continue
+			}
+			available--
+		}
+		err := m.stopTask(id, nil)
+		if err != nil {
+			log.Printf("Error stopping outdated task %v of service %s: %v \n", id, s.Name, err)
+			continue
+		}
+		log.Printf("Stopping outdated task %v of service %s \n", id, s.Name)
+		live = slices.DeleteFunc(live, func(i uuid.UUID) bool { return i == id })
+	}
+
+	return live
+}
+
+// canary keeps CanaryReplicas tasks of the new version running next to the old
+// ones for the analysis period, then promotes the rollout or aborts it
+func (m *Manager) canary(s *Service, live, current []uuid.UUID, ready int) []uuid.UUID {
+	r := s.Rollout
+	if r.Canaries > 0 && float64(r.CanaryFailures)/float64(r.Canaries) > s.Update.MaxFailureRate {
+		m.abort(s, fmt.Sprintf("%d of %d canaries failed", r.CanaryFailures, r.Canaries))
+		return live
+	}
+
+	want := min(s.Update.canaries(), s.Replicas)
+	for len(current) < want {
+		id := m.startServiceTask(s, s.Template, s.Version)
+		current = append(current, id)
+		live = append(live, id)
+		r.Canaries++
+	}
+	if r.AnalysisEndsAt.IsZero() {
+		r.AnalysisEndsAt = time.Now().UTC().Add(s.Update.analysisPeriod())
+		log.Printf("Analysing %d canaries of service %s until %v \n", want, s.Name, r.AnalysisEndsAt)
+	}
+	if time.Now().Before(r.AnalysisEndsAt) {
+		return live
+	}
+
+	if ready < want {
+		m.abort(s, fmt.Sprintf("%d of %d canaries healthy at the end of the analysis", ready, want))
+		return live
+	}
+	r.Phase = PhasePromoted
+	log.Printf("Promoting version %d of service %s, %d of %d canaries failed \n", s.Version, s.Name, r.CanaryFailures, r.Canaries)
+
+	return live
+}
+
+// abort gives up on a rollout and returns the service to the version it
+// rolled out from, reconcile then stops the tasks of the new version
+func (m *Manager) abort(s *Service, reason string) {
+	r := s.Rollout
+	r.State = RolloutAborted
+	r.Message = reason
+	r.FinishedAt = time.Now().UTC()
+	log.Printf("Aborting rollout of version %d of service %s: %s \n", s.Version, s.Name, reason)
+
+	if v, ok := s.version(r.FromVersion); ok {
+		s.Update = v.Update
+	}
+	if s.PreviousTemplate != nil {
+		s.Template = *s.PreviousTemplate
+	}
+	s.Version = r.FromVersion
+	s.PreviousTemplate = nil
+}
+
+// blueGreen starts a complete set of tasks of the new version and stops the
+// old ones all at once when every new task is healthy
+func (m *Manager) blueGreen(s *Service, live, current, outdated []uuid.UUID, ready int) []uuid.UUID {
+	for len(current) < s.Replicas {
+		id := m.startServiceTask(s, s.Template, s.Version)
+		current = append(current, id)
+		live = append(live, id)
+	}
+	if ready < s.Replicas {
+		return live
+	}
+
+	log.Printf("Version %d of service %s is healthy, switching over \n", s.Version, s.Name)
+	for _, id := range outdated {
+		err := m.stopTask(id, nil)
+		if err != nil {
+			log.Printf("Error stopping outdated task %v of service %s: %v \n", id, s.Name, err)
+			continue
+		}
+		live = slices.DeleteFunc(live, func(i uuid.UUID) bool { return i == id })
+	}
+
+	return live
+}
+
+// healthy reports a task that has been running for MinReady and, when it has
+// a health check, whose last check passed
+func (m *Manager) healthy(t *task.Task, u UpdateConfig) bool {
+	if t.HealthCheck != nil && t.Health != task.HealthHealthy {
+		return false
+	}
+	return t.State == task.Running && !t.StartTime.IsZero() && time.Since(t.StartTime) >= u.minReady()
+}
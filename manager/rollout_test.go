@@ -0,0 +1,167 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/arhantbararia/goat/task"
+)
+
+// rolledOut reports whether the rollout of the service reached the state
+func rolledOut(m *Manager, name string, state string) func() bool {
+	return func() bool {
+		r, err := m.GetRollout(name)
+		return err == nil && r.State == state
+	}
+}
+
+func TestRollingUpdateStaysWithinSurgeAndUnavailability(t *testing.T) {
+	m := New([]string{startWorker(t, task.FakeOptions{})})
+	update := UpdateConfig{MaxSurge: 1, MinReady: 1}
+	err := m.AddService(Service{Name: "web", Replicas: 3, Template: task.Task{Image: "img:1"}, Update: update})
+	if err != nil {
+		t.Fatalf("add service: %v", err)
+	}
+	settle(t, m, running(m, "web", 3))
+
+	err = m.UpdateService("web", task.Task{Image: "img:2"}, nil)
+	if err != nil {
+		t.Fatalf("update service: %v", err)
+	}
+	settle(t, m, func() bool {
+		s, _ := m.GetService("web")
+		if len(s.Tasks) > 4 {
+			t.Fatalf("service runs %d tasks, more than 3 replicas and 1 surge", len(s.Tasks))
+		}
+		if s.Running < 3 {
+			t.Fatalf("service dropped to %d running tasks with no unavailability allowed", s.Running)
+		}
+		return rolledOut(m, "web", RolloutCompleted)() && running(m, "web", 3)()
+	})
+
+	for _, id := range m.Services["web"].Tasks {
+		if got := m.TaskDb[id]; got.Image != "img:2" || got.Version != 2 {
+			t.Errorf("task %v runs %s of version %d after the rollout", id, got.Image, got.Version)
+		}
+	}
+}
+
+func TestRollingUpdatePausesWhenANewTaskFails(t *testing.T) {
+	m := New([]string{startWorker(t, task.FakeOptions{})})
+	err := m.AddService(Service{Name: "web", Replicas: 2, Template: task.Task{Image: "img:1"}, Update: UpdateConfig{MinReady: 1}})
+	if err != nil {
+		t.Fatalf("add service: %v", err)
+	}
+	settle(t, m, running(m, "web", 2))
+
+	// the secret does not exist, so tasks of the new version fail to start
+	broken := task.Task{Image: "img:2", Secrets: []task.SecretRef{{Name: "missing", Env: "TOKEN"}}}
+	err = m.UpdateService("web", broken, nil)
+	if err != nil {
+		t.Fatalf("update service: %v", err)
+	}
+	settle(t, m, rolledOut(m, "web", RolloutPaused))
+
+	// the tasks of the old version keep running
+	for i := 0; i < 3; i++ {
+		m.reconcileServices()
+		drain(m)
+		m.updateTasks()
+	}
+	r, _ := m.GetRollout("web")
+	if r.Message == "" {
+		t.Error("paused rollout does not say why")
+	}
+	old := 0
+	for _, id := range m.Services["web"].Tasks {
+		if got := m.TaskDb[id]; got != nil && got.Version == 1 && got.State == task.Running {
+			old++
+		}
+	}
+	if old != 2 {
+		t.Errorf("%d tasks of the old version running while the rollout is paused, want 2", old)
+	}
+}
+
+func TestCanaryIsPromotedWhenHealthy(t *testing.T) {
+	m := New([]string{startWorker(t, task.FakeOptions{})})
+	// long enough for the canary to get picked up by the worker and be ready
+	update := UpdateConfig{Strategy: StrategyCanary, MinReady: 1, AnalysisPeriod: 4}
+	err := m.AddService(Service{Name: "web", Replicas: 2, Template: task.Task{Image: "img:1"}, Update: update})
+	if err != nil {
+		t.Fatalf("add service: %v", err)
+	}
+	settle(t, m, running(m, "web", 2))
+
+	err = m.UpdateService("web", task.Task{Image: "img:2"}, nil)
+	if err != nil {
+		t.Fatalf("update service: %v", err)
+	}
+	settle(t, m, func() bool {
+		r, _ := m.GetRollout("web")
+		if r.Phase == PhaseCanary && r.Canaries > 1 {
+			t.Fatalf("started %d canaries, want 1", r.Canaries)
+		}
+		return r.State == RolloutCompleted && running(m, "web", 2)()
+	})
+
+	if r, _ := m.GetRollout("web"); r.Phase != PhasePromoted {
+		t.Errorf("completed rollout is in phase %q, want it promoted", r.Phase)
+	}
+}
+
+func TestCanaryAbortsWhenUnhealthy(t *testing.T) {
+	m := New([]string{startWorker(t, task.FakeOptions{})})
+	update := UpdateConfig{Strategy: StrategyCanary, MinReady: 1, AnalysisPeriod: 1}
+	err := m.AddService(Service{Name: "web", Replicas: 2, Template: task.Task{Image: "img:1"}, Update: update})
+	if err != nil {
+		t.Fatalf("add service: %v", err)
+	}
+	settle(t, m, running(m, "web", 2))
+
+	// the fake only reports a task healthy once its first check ran, long after the analysis
+	unhealthy := task.Task{Image: "img:2", HealthCheck: &task.HealthCheck{Cmd: []string{"true"}, Interval: 60}}
+	err = m.UpdateService("web", unhealthy, nil)
+	if err != nil {
+		t.Fatalf("update service: %v", err)
+	}
+	settle(t, m, rolledOut(m, "web", RolloutAborted))
+	settle(t, m, running(m, "web", 2))
+
+	s, _ := m.GetService("web")
+	if s.Version != 1 || s.Template.Image != "img:1" {
+		t.Errorf("aborted service is at version %d with image %s, want version 1", s.Version, s.Template.Image)
+	}
+	for _, id := range s.Tasks {
+		if got := m.TaskDb[id]; got.Version != 1 {
+			t.Errorf("task %v of version %d still counted after the abort", id, got.Version)
+		}
+	}
+}
+
+func TestBlueGreenSwitchesOverOnceTheNewSetIsHealthy(t *testing.T) {
+	m := New([]string{startWorker(t, task.FakeOptions{})})
+	update := UpdateConfig{Strategy: StrategyBlueGreen, MinReady: 1}
+	err := m.AddService(Service{Name: "web", Replicas: 2, Template: task.Task{Image: "img:1"}, Update: update})
+	if err != nil {
+		t.Fatalf("add service: %v", err)
+	}
+	settle(t, m, running(m, "web", 2))
+
+	err = m.UpdateService("web", task.Task{Image: "img:2"}, nil)
+	if err != nil {
+		t.Fatalf("update service: %v", err)
+	}
+	settle(t, m, func() bool {
+		s, _ := m.GetService("web")
+		versions := map[int]int{}
+		for _, id := range s.Tasks {
+			if got, ok := m.TaskDb[id]; ok && got.State == task.Running {
+				versions[got.Version]++
+			}
+		}
+		if versions[1] < 2 && versions[2] < 2 {
+			t.Fatalf("old tasks stopped with %d of the new ones running", versions[2])
+		}
+		return s.Rollout.State == RolloutCompleted && running(m, "web", 2)()
+	})
+}
@@ -0,0 +1,139 @@
+package manager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// SecretStore keeps secret values encrypted with AES-GCM, in memory and optionally in a file
+type SecretStore struct {
+	mu     sync.Mutex
+	aead   cipher.AEAD
+	path   string            //file the encrypted secrets are persisted to, empty keeps them in memory only
+	sealed map[string][]byte //name -> nonce followed by ciphertext
+}
+
+// NewSecretStore builds a store from a base64 encoded 32 byte key. With an empty
+// key a random one is generated, so secrets do not survive a manager restart.
+func NewSecretStore(key string, path string) (*SecretStore, error) {
+	raw := make([]byte, 32)
+	if key == "" {
+		rand.Read(raw)
+		path = ""
+	} else {
+		var err error
+		raw, err = base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("decoding secrets key: %v", err)
+		}
+		if len(raw) != 32 {
+			return nil, fmt.Errorf("secrets key must be 32 bytes, got %d", len(raw))
+		}
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SecretStore{
+		aead:   aead,
+		path:   path,
+		sealed: make(map[string][]byte),
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading secrets file: %v", err)
+		}
+		if err == nil {
+			err = json.Unmarshal(data, &s.sealed)
+			if err != nil {
+				return nil, fmt.Errorf("parsing secrets file: %v", err)
+			}
+		}
+	}
+
+	return s, nil
+}
+
+func (s *SecretStore) Set(name string, value string) error {
+	nonce := make([]byte, s.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the name is bound as additional data so sealed values cannot be swapped between names
+	s.sealed[name] = s.aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return s.save()
+}
+
+func (s *SecretStore) Get(name string) (string, error) {
+	s.mu.Lock()
+	sealed, ok := s.sealed[name]
+	s.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("secret %s not found", name)
+	}
+
+	n := s.aead.NonceSize()
+	if len(sealed) < n {
+		return "", fmt.Errorf("secret %s is corrupted", name)
+	}
+	value, err := s.aead.Open(nil, sealed[:n], sealed[n:], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("decrypting secret %s: %v", name, err)
+	}
+
+	return string(value), nil
+}
+
+func (s *SecretStore) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sealed, name)
+	return s.save()
+}
+
+// Names lists the stored secrets, never their values
+func (s *SecretStore) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := []string{}
+	for name := range s.sealed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (s *SecretStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.sealed)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
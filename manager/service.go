@@ -0,0 +1,356 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/arhantbararia/goat/task"
+	"github.com/google/uuid"
+)
+
+// how often the manager compares services against their running tasks
+var RECONCILE_TIME = 10
+
+// a task its worker has not reported for this long is considered lost
+var lostTimeout = 60 * time.Second
+
+var (
+	ErrServiceNotFound = errors.New("service not found")
+	ErrServiceExists   = errors.New("service already exists")
+)
+
+// Service keeps Replicas copies of its Template running
+type Service struct {
+	Name             string
+	Replicas         int
+	Template         task.Task
+	Version          int //bumped whenever Template changes
+	Update           UpdateConfig
+	Rollout          *Rollout         //the latest rollout, nil until the template first changes
+	PreviousTemplate *task.Task       //template a rollout moves away from, used while it is paused
+	Autoscale        *Autoscale       //sets Replicas from the usage of the tasks when set
+	Tasks            []uuid.UUID      //tasks currently counted towards Replicas
+	History          []ServiceVersion `json:",omitempty"` //only served by the history endpoint
+	Scaling          []ScalingEvent   `json:",omitempty"` //only served by the scaling endpoint
+	CreatedAt        time.Time
+
+	taskVersions map[uuid.UUID]int //template version each live task was started from
+}
+
+// ServiceVersion is a service spec as it was submitted
+type ServiceVersion struct {
+	Version   int
+	Template  task.Task
+	Update    UpdateConfig
+	Note      string //e.g. which version a rollback went back to
+	CreatedAt time.Time
+}
+
+// ServiceListing is a service as shown by the manager API
+type ServiceListing struct {
+	*Service
+	Running int //tasks of the service that are running right now
+}
+
+func (s *Service) validate() error {
+	if s.Name == "" {
+		return task.ValidationError{{Field: "Name", Message: "is required"}}
+	}
+	if s.Replicas < 0 {
+		return task.ValidationError{{Field: "Replicas", Message: "must not be negative"}}
+	}
+
+	if v := s.Update.validate(); len(v) > 0 {
+		return v
+	}
+	if s.Autoscale != nil {
+		if v := s.Autoscale.validate(); len(v) > 0 {
+			return v
+		}
+	}
+
+	// the template gets its ID when tasks are created from it
+	t := s.Template
+	t.ID = uuid.New()
+	return templateError(t.Validate())
+}
+
+// templateError points the field errors of a template at the Template field
+// of the service or job it belongs to
+func templateError(err error) error {
+	var v task.ValidationError
+	if !errors.As(err, &v) {
+		return err
+	}
+	for i := range v {
+		v[i].Field = "Template." + v[i].Field
+	}
+	return v
+}
+
+// AddService registers a new service, the reconcile loop starts its tasks
+func (m *Manager) AddService(s Service) error {
+	err := s.validate()
+	if err != nil {
+		return err
+	}
+	err = m.CheckPolicy(s.Template)
+	if err != nil {
+		return err
+	}
+
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+
+	if _, ok := m.Services[s.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrServiceExists, s.Name)
+	}
+	s.Tasks = []uuid.UUID{}
+	s.Template = s.Template.Spec()
+	s.Version = 1
+	s.History = nil
+	s.record("")
+	s.Scaling = nil
+	if s.Autoscale != nil {
+		s.Replicas = s.Autoscale.clamp(s.Replicas)
+	}
+	s.Rollout = nil
+	s.PreviousTemplate = nil
+	s.taskVersions = make(map[uuid.UUID]int)
+	s.CreatedAt = time.Now().UTC()
+	m.Services[s.Name] = &s
+
+	return nil
+}
+
+// record adds the current spec to the history of the service
+func (s *Service) record(note string) {
+	s.History = append(s.History, ServiceVersion{
+		Version:   s.Version,
+		Template:  s.Template,
+		Update:    s.Update,
+		Note:      note,
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// ScaleService changes the replica count of a service
+func (m *Manager) ScaleService(name string, replicas int) error {
+	if replicas < 0 {
+		return task.ValidationError{{Field: "Replicas", Message: "must not be negative"}}
+	}
+
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+
+	s, ok := m.Services[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	if s.Autoscale != nil {
+		return fmt.Errorf("%w: change the replica range of %s instead", ErrAutoscaled, name)
+	}
+	log.Printf("Scaling service %s from %d to %d replicas \n", name, s.Replicas, replicas)
+	s.Replicas = replicas
+
+	return nil
+}
+
+// RemoveService deletes a service and stops all of its tasks
+func (m *Manager) RemoveService(name string) error {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.Services[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	for _, id := range s.Tasks {
+		err := m.stopTask(id, nil)
+		if err != nil {
+			log.Printf("Error stopping task %v of service %s: %v \n", id, name, err)
+		}
+	}
+	delete(m.Services, name)
+
+	return nil
+}
+
+func (m *Manager) GetServices() []ServiceListing {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	services := []ServiceListing{}
+	for _, name := range slices.Sorted(maps.Keys(m.Services)) {
+		services = append(services, m.serviceListing(m.Services[name]))
+	}
+	return services
+}
+
+func (m *Manager) GetService(name string) (ServiceListing, bool) {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.Services[name]
+	if !ok {
+		return ServiceListing{}, false
+	}
+	return m.serviceListing(s), true
+}
+
+// GetServiceHistory returns every version of a service, oldest first
+func (m *Manager) GetServiceHistory(name string) ([]ServiceVersion, error) {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+
+	s, ok := m.Services[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	return slices.Clone(s.History), nil
+}
+
+func (m *Manager) serviceListing(s *Service) ServiceListing {
+	copied := *s
+	copied.Tasks = slices.Clone(s.Tasks)
+	copied.History = nil
+	copied.Scaling = nil
+	listing := ServiceListing{Service: &copied}
+	for _, id := range s.Tasks {
+		if t, ok := m.TaskDb[id]; ok && t.State == task.Running {
+			listing.Running++
+		}
+	}
+	return listing
+}
+
+func (m *Manager) ReconcileServices() {
+	for {
+		log.Println("Reconciling services")
+		m.reconcileServices()
+		time.Sleep(time.Duration(RECONCILE_TIME) * time.Second)
+	}
+}
+
+func (m *Manager) reconcileServices() {
+	m.servicesMu.Lock()
+	defer m.servicesMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.Services {
+		m.reconcile(s)
+	}
+}
+
+// reconcile drops the tasks of a service that ended or got lost, then starts
+// or stops tasks until Replicas of them are live
+func (m *Manager) reconcile(s *Service) {
+	live := []uuid.UUID{}
+	for _, id := range s.Tasks {
+		t, ok := m.TaskDb[id]
+		if !ok {
+			// still waiting in the pending queue
+			live = append(live, id)
+			continue
+		}
+		if m.lost(t) {
+			log.Printf("Task %v of service %s is lost \n", id, s.Name)
+			t.State = task.Failed
+			t.FinishTime = time.Now().UTC()
+			t.FinishReason = task.ReasonLost
+			t.Error = fmt.Sprintf("not reported by worker %s for %v", m.TaskWorkerMap[id], lostTimeout)
+			m.started(id)
+		}
+		if t.State == task.Failed || t.State == task.Completed {
+			log.Printf("Task %v of service %s ended as %v, replacing it \n", id, s.Name, t.State)
+			s.failed(t)
+			delete(s.taskVersions, id)
+			continue
+		}
+		live = append(live, id)
+	}
+
+	if s.Rollout.active() {
+		live = m.rollOut(s, live)
+		if s.Rollout.active() {
+			s.Tasks = live
+			return
+		}
+	}
+
+	// tasks of a version the service no longer runs, left behind by an aborted rollout
+	for _, id := range slices.Clone(live) {
+		if s.taskVersions[id] == s.Version {
+			continue
+		}
+		err := m.stopTask(id, nil)
+		if err != nil {
+			log.Printf("Error stopping task %v of service %s: %v \n", id, s.Name, err)
+			continue
+		}
+		log.Printf("Stopping task %v of version %d of service %s \n", id, s.taskVersions[id], s.Name)
+		delete(s.taskVersions, id)
+		live = slices.DeleteFunc(live, func(i uuid.UUID) bool { return i == id })
+	}
+
+	for len(live) < s.Replicas {
+		live = append(live, m.startServiceTask(s, s.Template, s.Version))
+	}
+
+	// remove the newest extras first, they are the likeliest to be still queued
+	for i := len(live) - 1; i >= 0 && len(live) > s.Replicas; i-- {
+		id := live[i]
+		err := m.stopTask(id, nil)
+		if err != nil {
+			log.Printf("Error stopping extra task %v of service %s: %v \n", id, s.Name, err)
+			continue
+		}
+		log.Printf("Stopping extra task %v of service %s \n", id, s.Name)
+		delete(s.taskVersions, id)
+		live = slices.Delete(live, i, i+1)
+	}
+
+	s.Tasks = live
+}
+
+// startServiceTask queues a new task of the service from the given template version
+func (m *Manager) startServiceTask(s *Service, template task.Task, version int) uuid.UUID {
+	t := template
+	t.ID = uuid.New()
+	t.Name = fmt.Sprintf("%s-%s", s.Name, t.ID.String()[:8])
+	t.State = task.Scheduled
+	t.Service = s.Name
+	t.Version = version
+
+	m.addTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Running,
+		TimeStamp: time.Now().UTC(),
+		Task:      t,
+	})
+	s.taskVersions[t.ID] = version
+	log.Printf("Started task %v for version %d of service %s \n", t.ID, version, s.Name)
+
+	return t.ID
+}
+
+// lost reports a task its worker stopped reporting, or never reported after
+// taking it, e.g. because it went away before starting it
+func (m *Manager) lost(t *task.Task) bool {
+	if t.State != task.Scheduled && t.State != task.Running && t.State != task.Paused {
+		return false
+	}
+	seen, ok := m.LastSeen[t.ID]
+	return ok && time.Since(seen) > lostTimeout
+}
@@ -0,0 +1,40 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/arhantbararia/goat/task"
+)
+
+// running reports whether the service has exactly n tasks and all of them run
+func running(m *Manager, name string, n int) func() bool {
+	return func() bool {
+		s, ok := m.GetService(name)
+		return ok && len(s.Tasks) == n && s.Running == n
+	}
+}
+
+func TestReconcileReplacesEndedTasksAndScales(t *testing.T) {
+	m := New([]string{startWorker(t, task.FakeOptions{})})
+	err := m.AddService(Service{Name: "web", Replicas: 2, Template: task.Task{Image: "img"}})
+	if err != nil {
+		t.Fatalf("add service: %v", err)
+	}
+	settle(t, m, running(m, "web", 2))
+
+	stopped := m.Services["web"].Tasks[0]
+	err = m.StopTask(stopped, nil)
+	if err != nil {
+		t.Fatalf("stop task: %v", err)
+	}
+	settle(t, m, func() bool {
+		s, _ := m.GetService("web")
+		return running(m, "web", 2)() && s.Tasks[0] != stopped && s.Tasks[1] != stopped
+	})
+
+	err = m.ScaleService("web", 1)
+	if err != nil {
+		t.Fatalf("scale service: %v", err)
+	}
+	settle(t, m, running(m, "web", 1))
+}
@@ -0,0 +1,182 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+)
+
+const DriverDocker = "docker"
+
+// Driver runs tasks on a worker. Docker is the default implementation.
+type Driver interface {
+	Name() string
+	Start(c Config) DockerResult
+	Stop(c Config) DockerResult
+	Inspect(id string) (Status, error)
+	Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error)
+	Stats(id string) (Usage, error)
+}
+
+// Status is the runtime view of a started task
+type Status struct {
+	ID         string
+	Running    bool
+	ExitCode   int
+	OOMKilled  bool
+	Error      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Members    []MemberStatus //sidecars of a task group
+	Health     string         //starting, healthy or unhealthy when the task has a health check
+}
+
+// ExitState maps how a task's process ended to its final state
+func (s Status) ExitState() State {
+	if s.ExitCode == 0 && s.Error == "" && !s.OOMKilled {
+		return Completed
+	}
+	return Failed
+}
+
+// Usage is a single resource usage sample of a started task
+type Usage struct {
+	CpuPercent  float64
+	MemoryUsage uint64
+	MemoryLimit uint64
+	NetworkRx   uint64
+	NetworkTx   uint64
+	BlockRead   uint64
+	BlockWrite  uint64
+}
+
+// UsageSample is a Usage taken at a point in time
+type UsageSample struct {
+	Time time.Time
+	Usage
+}
+
+// DriverName returns the driver a task runs on
+func DriverName(t Task) string {
+	if t.Driver == "" {
+		return DriverDocker
+	}
+	return t.Driver
+}
+
+func (d *Docker) Inspect(id string) (Status, error) {
+	resp, err := d.Client.ContainerInspect(context.Background(), id, client.ContainerInspectOptions{})
+	if err != nil {
+		return Status{}, err
+	}
+
+	status := Status{ID: resp.Container.ID}
+	if st := resp.Container.State; st != nil {
+		status.Running = st.Running
+		status.ExitCode = st.ExitCode
+		status.OOMKilled = st.OOMKilled
+		status.Error = st.Error
+		status.StartedAt, _ = time.Parse(time.RFC3339Nano, st.StartedAt)
+		status.FinishedAt, _ = time.Parse(time.RFC3339Nano, st.FinishedAt)
+		if st.Health != nil {
+			status.Health = string(st.Health.Status)
+		}
+	}
+
+	members, err := d.inspectSidecars(context.Background(), resp.Container.ID)
+	if err != nil {
+		return Status{}, err
+	}
+	if len(members) > 0 {
+		status = groupStatus(status, members)
+	}
+
+	return status, nil
+}
+
+func (d *Docker) Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error) {
+	dockerOpts := client.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Timestamps: opts.Timestamps,
+		Follow:     opts.Follow,
+	}
+	if opts.Tail > 0 {
+		dockerOpts.Tail = strconv.Itoa(opts.Tail)
+	}
+	if !opts.Since.IsZero() {
+		dockerOpts.Since = opts.Since.Format(time.RFC3339Nano)
+	}
+
+	resp, err := d.Client.ContainerLogs(ctx, id, dockerOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	// containers run without a TTY, so stdout and stderr come multiplexed
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, resp)
+		resp.Close()
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+func (d *Docker) Stats(id string) (Usage, error) {
+	resp, err := d.Client.ContainerStats(context.Background(), id, client.ContainerStatsOptions{
+		IncludePreviousSample: true,
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+	defer resp.Body.Close()
+
+	var s container.StatsResponse
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	return dockerUsage(s), nil
+}
+
+func dockerUsage(s container.StatsResponse) Usage {
+	u := Usage{
+		MemoryUsage: s.MemoryStats.Usage,
+		MemoryLimit: s.MemoryStats.Limit,
+	}
+
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		cpus := float64(s.CPUStats.OnlineCPUs)
+		if cpus == 0 {
+			cpus = 1
+		}
+		u.CpuPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	for _, n := range s.Networks {
+		u.NetworkRx += n.RxBytes
+		u.NetworkTx += n.TxBytes
+	}
+
+	for _, e := range s.BlkioStats.IoServiceBytesRecursive {
+		switch e.Op {
+		case "read", "Read":
+			u.BlockRead += e.Value
+		case "write", "Write":
+			u.BlockWrite += e.Value
+		}
+	}
+
+	return u
+}
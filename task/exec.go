@@ -0,0 +1,285 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/c9s/goprocinfo/linux"
+	"github.com/google/uuid"
+)
+
+const DriverExec = "exec"
+
+// how long a process group gets between its stop signal and SIGKILL, unless the task sets StopGracePeriod
+var ExecStopTimeout = 10 * time.Second
+
+// environment every process starts with, the worker's own is not passed on
+// so its credentials don't leak into tasks
+var ExecBaseEnv = []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}
+
+// clock ticks per second used by /proc/<pid>/stat times
+const clockTicks = 100
+
+type execProcess struct {
+	cmd        *exec.Cmd
+	output     *logBuffer
+	startedAt  time.Time
+	finishedAt time.Time
+	exitCode   int
+	err        error
+	done       chan struct{}
+}
+
+// Exec runs tasks as plain host processes, each in its own process group
+type Exec struct {
+	mu        sync.Mutex
+	processes map[string]*execProcess
+}
+
+func NewExec() *Exec {
+	return &Exec{
+		processes: make(map[string]*execProcess),
+	}
+}
+
+func (e *Exec) Name() string {
+	return DriverExec
+}
+
+func (e *Exec) Start(c Config) DockerResult {
+	if len(c.Cmd) == 0 {
+		return DockerResult{Error: fmt.Errorf("task %s has no command to execute", c.Name)}
+	}
+
+	if len(c.InitContainers) > 0 || len(c.Sidecars) > 0 {
+		return DockerResult{Error: fmt.Errorf("exec driver cannot run task groups")}
+	}
+
+	if c.Security != nil {
+		return DockerResult{Error: fmt.Errorf("exec driver cannot apply security settings")}
+	}
+
+	if c.HealthCheck != nil {
+		return DockerResult{Error: fmt.Errorf("exec driver cannot run health checks")}
+	}
+
+	// processes share the host filesystem, so secrets can only be handed over as env
+	for _, ref := range c.Secrets {
+		if ref.File != "" {
+			return DockerResult{Error: fmt.Errorf("exec driver cannot mount secret %s as a file", ref.Name)}
+		}
+	}
+	secretEnv, _, err := prepareSecrets(c)
+	if err != nil {
+		return DockerResult{Error: err}
+	}
+
+	env := append(append(slices.Clone(ExecBaseEnv), c.Env...), secretEnv...)
+	id, err := e.spawn(c.Cmd[0], c.Cmd[1:], env, c.WorkingDir)
+	if err != nil {
+		log.Printf("error starting process %s: %v \n", c.Cmd[0], err)
+		return DockerResult{Error: err}
+	}
+
+	return DockerResult{
+		ContainerId: id,
+		Action:      "start",
+		Result:      "success",
+	}
+}
+
+// spawn starts a process in its own process group and returns the ID it is tracked by
+func (e *Exec) spawn(name string, args []string, env []string, dir string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Env = env
+	cmd.Dir = dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// don't let children that inherited stdout keep Wait from returning
+	cmd.WaitDelay = time.Second
+
+	p := &execProcess{
+		cmd:    cmd,
+		output: newLogBuffer(),
+		done:   make(chan struct{}),
+	}
+	cmd.Stdout = p.output
+	cmd.Stderr = p.output
+
+	err := cmd.Start()
+	if err != nil {
+		return "", err
+	}
+	p.startedAt = time.Now().UTC()
+
+	go func() {
+		err := cmd.Wait()
+		p.finishedAt = time.Now().UTC()
+		p.exitCode = cmd.ProcessState.ExitCode()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			p.err = err
+		}
+		p.output.Close()
+		close(p.done)
+	}()
+
+	id := uuid.NewString()
+
+	e.mu.Lock()
+	e.processes[id] = p
+	e.mu.Unlock()
+
+	log.Printf("Started process %s (pid %d) as %s \n", filepath.Base(name), cmd.Process.Pid, id)
+
+	return id, nil
+}
+
+func (e *Exec) process(id string) (*execProcess, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	p, ok := e.processes[id]
+	if !ok {
+		return nil, fmt.Errorf("no such process: %s", id)
+	}
+	return p, nil
+}
+
+// Stop terminates the whole process group, escalating to SIGKILL after ExecStopTimeout
+func (e *Exec) Stop(c Config) DockerResult {
+	p, err := e.process(c.ContainerID)
+	if err != nil {
+		return DockerResult{Error: err}
+	}
+
+	sig, err := stopSignal(c.StopSignal)
+	if err != nil {
+		log.Printf("%v, using SIGTERM \n", err)
+		sig = syscall.SIGTERM
+	}
+	grace := ExecStopTimeout
+	if c.StopGracePeriod != nil {
+		grace = time.Duration(*c.StopGracePeriod) * time.Second
+	}
+	if c.ForceStop {
+		sig = syscall.SIGKILL
+	}
+
+	pgid := p.cmd.Process.Pid
+	select {
+	case <-p.done:
+	default:
+		e.runPreStop(c)
+		log.Printf("Sending %v to process group %d \n", sig, pgid)
+		syscall.Kill(-pgid, sig)
+		if sig == syscall.SIGKILL {
+			<-p.done
+		}
+		select {
+		case <-p.done:
+		case <-time.After(grace):
+			log.Printf("Process group %d still running, sending SIGKILL \n", pgid)
+			syscall.Kill(-pgid, syscall.SIGKILL)
+			<-p.done
+		}
+	}
+	// children that outlived the leader still belong to the group
+	syscall.Kill(-pgid, syscall.SIGKILL)
+
+	e.mu.Lock()
+	delete(e.processes, c.ContainerID)
+	e.mu.Unlock()
+
+	return DockerResult{
+		Action: "stop",
+		Result: "success",
+	}
+}
+
+func (e *Exec) Inspect(id string) (Status, error) {
+	p, err := e.process(id)
+	if err != nil {
+		return Status{}, err
+	}
+
+	status := Status{
+		ID:        id,
+		StartedAt: p.startedAt,
+	}
+	select {
+	case <-p.done:
+		status.ExitCode = p.exitCode
+		status.FinishedAt = p.finishedAt
+		if p.err != nil {
+			status.Error = p.err.Error()
+		}
+	default:
+		status.Running = true
+	}
+
+	return status, nil
+}
+
+func (e *Exec) Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error) {
+	p, err := e.process(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.output.Reader(ctx, opts), nil
+}
+
+// Stats sums memory and cpu time of every process in the task's process group from /proc
+func (e *Exec) Stats(id string) (Usage, error) {
+	p, err := e.process(id)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	select {
+	case <-p.done:
+		return Usage{}, nil
+	default:
+	}
+
+	maxPid, err := linux.ReadMaxPID("/proc/sys/kernel/pid_max")
+	if err != nil {
+		return Usage{}, err
+	}
+	pids, err := linux.ListPID("/proc", maxPid)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	pgid := int64(p.cmd.Process.Pid)
+	var rssPages int64
+	var cpuTicks uint64
+	for _, pid := range pids {
+		stat, err := linux.ReadProcessStat(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil || stat.Pgrp != pgid {
+			continue
+		}
+		rssPages += stat.Rss
+		cpuTicks += stat.Utime + stat.Stime
+	}
+
+	u := Usage{
+		MemoryUsage: uint64(rssPages) * uint64(os.Getpagesize()),
+	}
+	elapsed := time.Since(p.startedAt).Seconds()
+	if elapsed > 0 {
+		u.CpuPercent = float64(cpuTicks) / clockTicks / elapsed * 100
+	}
+
+	return u, nil
+}
@@ -0,0 +1,151 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/moby/moby/client"
+)
+
+// ExecRequest describes a command to run inside a started task
+type ExecRequest struct {
+	Cmd        []string
+	Env        []string
+	WorkingDir string
+	User       string
+	Height     uint //initial terminal size, interactive sessions only
+	Width      uint
+	Timeout    int //seconds to wait for a non-interactive command, 0 waits until it exits
+}
+
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// ExecSession is an interactive command attached to a TTY
+type ExecSession interface {
+	io.ReadWriteCloser
+	Resize(height, width uint) error
+	ExitCode() (int, error) //only meaningful once reads hit EOF
+}
+
+// Execer is implemented by drivers that can run commands inside a started task
+type Execer interface {
+	Exec(ctx context.Context, id string, req ExecRequest) (ExecResult, error)
+	ExecInteractive(ctx context.Context, id string, req ExecRequest) (ExecSession, error)
+}
+
+// Exec runs a command and collects its output. Docker can't kill an exec, so a
+// command still running when ctx is done is left behind and only the wait ends.
+func (d *Docker) Exec(ctx context.Context, id string, req ExecRequest) (ExecResult, error) {
+	created, err := d.Client.ExecCreate(ctx, id, client.ExecCreateOptions{
+		User:         req.User,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          req.Env,
+		WorkingDir:   req.WorkingDir,
+		Cmd:          req.Cmd,
+	})
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	attached, err := d.Client.ExecAttach(ctx, created.ID, client.ExecAttachOptions{})
+	if err != nil {
+		return ExecResult{}, err
+	}
+	defer attached.Close()
+	stop := context.AfterFunc(ctx, attached.Close)
+	defer stop()
+
+	var stdout, stderr bytes.Buffer
+	_, err = stdcopy.StdCopy(&stdout, &stderr, attached.Reader)
+	if ctx.Err() != nil {
+		return ExecResult{}, ctx.Err()
+	}
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	inspected, err := d.Client.ExecInspect(ctx, created.ID, client.ExecInspectOptions{})
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	return ExecResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: inspected.ExitCode,
+	}, nil
+}
+
+type dockerExecSession struct {
+	client.HijackedResponse
+	docker *Docker
+	execID string
+}
+
+func (s *dockerExecSession) Read(p []byte) (int, error) {
+	return s.Reader.Read(p)
+}
+
+func (s *dockerExecSession) Write(p []byte) (int, error) {
+	return s.Conn.Write(p)
+}
+
+func (s *dockerExecSession) Close() error {
+	return s.Conn.Close()
+}
+
+func (s *dockerExecSession) Resize(height, width uint) error {
+	_, err := s.docker.Client.ExecResize(context.Background(), s.execID, client.ExecResizeOptions{
+		Height: height,
+		Width:  width,
+	})
+	return err
+}
+
+func (s *dockerExecSession) ExitCode() (int, error) {
+	inspected, err := s.docker.Client.ExecInspect(context.Background(), s.execID, client.ExecInspectOptions{})
+	if err != nil {
+		return 0, err
+	}
+	return inspected.ExitCode, nil
+}
+
+func (d *Docker) ExecInteractive(ctx context.Context, id string, req ExecRequest) (ExecSession, error) {
+	size := client.ConsoleSize{Height: req.Height, Width: req.Width}
+
+	created, err := d.Client.ExecCreate(ctx, id, client.ExecCreateOptions{
+		User:         req.User,
+		TTY:          true,
+		ConsoleSize:  size,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          req.Env,
+		WorkingDir:   req.WorkingDir,
+		Cmd:          req.Cmd,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attached, err := d.Client.ExecAttach(ctx, created.ID, client.ExecAttachOptions{
+		TTY:         true,
+		ConsoleSize: size,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dockerExecSession{
+		HijackedResponse: attached.HijackedResponse,
+		docker:           d,
+		execID:           created.ID,
+	}, nil
+}
@@ -0,0 +1,284 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const DriverFake = "fake"
+
+// FakeOptions control how the fake driver simulates containers
+type FakeOptions struct {
+	PullDelay     time.Duration //simulated image pull time
+	StartDelay    time.Duration //simulated container start time
+	RunTime       time.Duration //how long a container runs before exiting on its own, 0 runs until stopped
+	ExitCode      int           //exit code of containers that exit on their own
+	CrashAfter    time.Duration //how long a crashing container runs before it dies, 0 dies right after starting
+	CrashRate     float64       //fraction of started containers that crash with exit code 1
+	FailPullRate  float64       //fraction of starts that fail pulling the image
+	FailStartRate float64       //fraction of starts that fail creating the container
+	Rand          *rand.Rand    //source of the simulated failures, seeded from the clock when nil
+}
+
+type fakeContainer struct {
+	config     Config
+	startedAt  time.Time
+	finishedAt time.Time
+	exitAfter  time.Duration
+	exitCode   int
+	oomKilled  bool
+	stopped    bool
+	paused     bool
+	logs       *logBuffer
+}
+
+// Fake is an in-memory driver for running workers without a Docker daemon
+type Fake struct {
+	Options FakeOptions
+
+	mu         sync.Mutex
+	containers map[string]*fakeContainer
+}
+
+func NewFake(opts FakeOptions) *Fake {
+	if opts.Rand == nil {
+		opts.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return &Fake{
+		Options:    opts,
+		containers: make(map[string]*fakeContainer),
+	}
+}
+
+func (f *Fake) Name() string {
+	return DriverFake
+}
+
+// roll draws from the fake's source, which is not safe for concurrent use on its own
+func (f *Fake) roll() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Options.Rand.Float64()
+}
+
+func (f *Fake) Start(c Config) DockerResult {
+	time.Sleep(f.Options.PullDelay)
+	if f.roll() < f.Options.FailPullRate {
+		return DockerResult{Error: fmt.Errorf("pulling image %s: simulated pull failure", c.Image)}
+	}
+
+	time.Sleep(f.Options.StartDelay)
+	if f.roll() < f.Options.FailStartRate {
+		return DockerResult{Error: fmt.Errorf("creating container for %s: simulated start failure", c.Name)}
+	}
+
+	fc := &fakeContainer{
+		config:    c,
+		startedAt: time.Now().UTC(),
+		exitAfter: f.Options.RunTime,
+		exitCode:  f.Options.ExitCode,
+		logs:      newLogBuffer(),
+	}
+	if f.roll() < f.Options.CrashRate {
+		fc.exitAfter = max(f.Options.CrashAfter, time.Nanosecond)
+		fc.exitCode = 1
+	}
+	for _, m := range c.InitContainers {
+		fmt.Fprintf(fc.logs, "fake init container %s completed\n", m.Name)
+	}
+	fmt.Fprintf(fc.logs, "fake container %s started from image %s\n", c.Name, c.Image)
+
+	id := uuid.NewString()
+
+	f.mu.Lock()
+	f.containers[id] = fc
+	f.mu.Unlock()
+
+	return DockerResult{
+		ContainerId: id,
+		Action:      "start",
+		Result:      "success",
+	}
+}
+
+func (f *Fake) Stop(c Config) DockerResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[c.ContainerID]
+	if !ok {
+		return DockerResult{Error: fmt.Errorf("no such container: %s", c.ContainerID)}
+	}
+	if !fc.stopped {
+		if c.PreStop != nil && !c.ForceStop && !fc.exited() {
+			fmt.Fprintf(fc.logs, "fake pre-stop hook of %s ran\n", fc.config.Name)
+		}
+		fc.stopped = true
+		fc.finishedAt = time.Now().UTC()
+		fc.logs.Close()
+	}
+	delete(f.containers, c.ContainerID)
+
+	return DockerResult{
+		Action: "stop",
+		Result: "success",
+	}
+}
+
+// Crash makes a running container exit immediately with the given exit code
+func (f *Fake) Crash(id string, exitCode int, oomKilled bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[id]
+	if !ok {
+		return fmt.Errorf("no such container: %s", id)
+	}
+	fc.exitAfter = time.Since(fc.startedAt)
+	fc.exitCode = exitCode
+	fc.oomKilled = oomKilled
+	fmt.Fprintf(fc.logs, "fake container %s crashed with exit code %d\n", fc.config.Name, exitCode)
+
+	return nil
+}
+
+// exited reports whether the container has stopped running on its own
+func (fc *fakeContainer) exited() bool {
+	return fc.exitAfter > 0 && time.Since(fc.startedAt) >= fc.exitAfter
+}
+
+func (f *Fake) Inspect(id string) (Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[id]
+	if !ok {
+		return Status{}, fmt.Errorf("no such container: %s", id)
+	}
+
+	status := Status{
+		ID:        id,
+		Running:   !fc.stopped && !fc.exited(),
+		StartedAt: fc.startedAt,
+	}
+	// health checks always pass, once the first one would have run
+	if h := fc.config.HealthCheck; h != nil && status.Running {
+		status.Health = HealthStarting
+		if time.Since(fc.startedAt) >= dockerHealthCheck(h).Interval {
+			status.Health = HealthHealthy
+		}
+	}
+	if fc.exited() {
+		status.ExitCode = fc.exitCode
+		status.OOMKilled = fc.oomKilled
+		status.FinishedAt = fc.startedAt.Add(fc.exitAfter)
+	}
+	// sidecars are not simulated on their own, they live and die with the main container
+	for _, m := range fc.config.Sidecars {
+		status.Members = append(status.Members, MemberStatus{
+			Name:        m.Name,
+			ContainerID: id + "-" + m.Name,
+			Running:     status.Running,
+		})
+	}
+
+	return status, nil
+}
+
+func (f *Fake) Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[id]
+	if !ok {
+		return nil, fmt.Errorf("no such container: %s", id)
+	}
+
+	return fc.logs.Reader(ctx, opts), nil
+}
+
+func (f *Fake) Stats(id string) (Usage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[id]
+	if !ok {
+		return Usage{}, fmt.Errorf("no such container: %s", id)
+	}
+	if fc.stopped || fc.exited() {
+		return Usage{MemoryLimit: uint64(fc.config.Memory)}, nil
+	}
+
+	return Usage{
+		CpuPercent:  f.Options.Rand.Float64() * 100 * max(fc.config.Cpu, 1),
+		MemoryUsage: uint64(fc.config.Memory) / 2,
+		MemoryLimit: uint64(fc.config.Memory),
+	}, nil
+}
+
+func (f *Fake) running(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[id]
+	if !ok {
+		return fmt.Errorf("no such container: %s", id)
+	}
+	if fc.stopped || fc.exited() {
+		return fmt.Errorf("container %s is not running", id)
+	}
+	return nil
+}
+
+// Exec pretends to run the command, echoing it back on stdout
+func (f *Fake) Exec(ctx context.Context, id string, req ExecRequest) (ExecResult, error) {
+	err := f.running(id)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	if ctx.Err() != nil {
+		return ExecResult{}, ctx.Err()
+	}
+
+	return ExecResult{Stdout: fmt.Sprintln(strings.Join(req.Cmd, " "))}, nil
+}
+
+// fakeExecSession echoes whatever is written to it, like a terminal running cat
+type fakeExecSession struct {
+	*io.PipeReader
+	w *io.PipeWriter
+}
+
+func (s *fakeExecSession) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *fakeExecSession) Close() error {
+	s.w.Close()
+	return s.PipeReader.Close()
+}
+
+func (s *fakeExecSession) Resize(height, width uint) error {
+	return nil
+}
+
+func (s *fakeExecSession) ExitCode() (int, error) {
+	return 0, nil
+}
+
+func (f *Fake) ExecInteractive(ctx context.Context, id string, req ExecRequest) (ExecSession, error) {
+	err := f.running(id)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	return &fakeExecSession{PipeReader: pr, w: pw}, nil
+}
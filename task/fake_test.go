@@ -0,0 +1,86 @@
+package task
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// starts that fail with a seeded source
+func failures(seed int64, n int) []bool {
+	f := NewFake(FakeOptions{FailStartRate: 0.5, Rand: rand.New(rand.NewSource(seed))})
+	failed := make([]bool, n)
+	for i := range failed {
+		failed[i] = f.Start(Config{Name: "t", Image: "img"}).Error != nil
+	}
+	return failed
+}
+
+func TestFakeSeededFailuresRepeat(t *testing.T) {
+	a, b := failures(7, 20), failures(7, 20)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("start %d: failed %v with one source and %v with the other", i, a[i], b[i])
+		}
+	}
+}
+
+func TestFakeCrash(t *testing.T) {
+	f := NewFake(FakeOptions{CrashRate: 1, CrashAfter: time.Millisecond, Rand: rand.New(rand.NewSource(1))})
+	result := f.Start(Config{Name: "t", Image: "img"})
+	if result.Error != nil {
+		t.Fatalf("start: %v", result.Error)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	status, err := f.Inspect(result.ContainerId)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if status.Running || status.ExitCode != 1 {
+		t.Fatalf("got running %v with exit code %d, want a crash with exit code 1", status.Running, status.ExitCode)
+	}
+}
+
+func TestFakeCrashAfterZeroStillCrashes(t *testing.T) {
+	f := NewFake(FakeOptions{CrashRate: 1, Rand: rand.New(rand.NewSource(1))})
+	result := f.Start(Config{Name: "t", Image: "img"})
+
+	status, err := f.Inspect(result.ContainerId)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if status.Running {
+		t.Fatal("container with CrashAfter 0 is still running")
+	}
+}
+
+func TestFakeFollowedLogsEndWithContext(t *testing.T) {
+	f := NewFake(FakeOptions{Rand: rand.New(rand.NewSource(1))})
+	result := f.Start(Config{Name: "t", Image: "img"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	logs, err := f.Logs(ctx, result.ContainerId, LogOptions{Follow: true})
+	if err != nil {
+		t.Fatalf("logs: %v", err)
+	}
+	defer logs.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := io.ReadAll(logs)
+		done <- err
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("got %v, want the stream to end with %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("following the logs did not stop with the context")
+	}
+}
@@ -0,0 +1,265 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+)
+
+// labels tying sidecar containers to the main container of their group
+const (
+	groupLabel  = "goat.group"
+	memberLabel = "goat.member"
+)
+
+// how long an init container may run when it sets no Timeout
+var InitTimeout = 10 * time.Minute
+
+// Container is an extra container of a task group. Init containers run to
+// completion, one after the other, before the main container starts. Sidecars
+// start after it and share its network namespace.
+type Container struct {
+	Name       string
+	Image      string
+	PullPolicy PullPolicy
+	Cmd        []string
+	Env        []string
+	WorkingDir string
+	Mounts     []Mount
+	Timeout    int //seconds an init container may run before the task fails, InitTimeout when 0
+}
+
+// MemberStatus is the runtime view of one sidecar of a started task group
+type MemberStatus struct {
+	Name        string
+	ContainerID string
+	Running     bool
+	ExitCode    int
+	Error       string
+}
+
+// memberConfig is the config used to pull and create one member of the group
+func (c Config) memberConfig(m Container) Config {
+	mc := Config{
+		TaskID:     c.TaskID,
+		Name:       c.Name + "-" + m.Name,
+		Image:      m.Image,
+		PullPolicy: m.PullPolicy,
+		Cmd:        m.Cmd,
+		Env:        m.Env,
+		WorkingDir: m.WorkingDir,
+		Mounts:     m.Mounts,
+		Security:   c.Security,
+	}
+	if c.Name == "" {
+		mc.Name = ""
+	}
+	// the manager picks credentials for the main image, they only apply to the same registry
+	if c.RegistryAuth != nil && RegistryHost(m.Image) == RegistryHost(c.Image) {
+		mc.RegistryAuth = c.RegistryAuth
+	}
+	return mc
+}
+
+// groupStatus folds the sidecars into the status of the main container: the
+// group runs while all of its members run, the first one to exit ends it.
+func groupStatus(status Status, members []MemberStatus) Status {
+	status.Members = members
+	if !status.Running {
+		return status
+	}
+
+	for _, m := range members {
+		if m.Running {
+			continue
+		}
+		status.Running = false
+		status.ExitCode = m.ExitCode
+		status.Error = fmt.Sprintf("sidecar %s exited with code %d", m.Name, m.ExitCode)
+		if m.Error != "" {
+			status.Error = fmt.Sprintf("sidecar %s failed: %s", m.Name, m.Error)
+		}
+		return status
+	}
+
+	return status
+}
+
+// runInit runs an init container to completion and removes it
+func (d *Docker) runInit(ctx context.Context, c Config, m Container) error {
+	mc := c.memberConfig(m)
+
+	err := d.pullImage(ctx, mc)
+	if err != nil {
+		return err
+	}
+
+	cc := container.Config{
+		Image:      mc.Image,
+		Cmd:        mc.Cmd,
+		WorkingDir: mc.WorkingDir,
+		Env:        mc.Env,
+	}
+	hc := container.HostConfig{
+		Mounts: dockerMounts(mc.Mounts),
+	}
+	err = applySecurity(&cc, &hc, mc.Security)
+	if err != nil {
+		return fmt.Errorf("init container %s: %w", m.Name, err)
+	}
+
+	resp, err := d.Client.ContainerCreate(ctx, client.ContainerCreateOptions{
+		Config:           &cc,
+		HostConfig:       &hc,
+		NetworkingConfig: networkingConfig(c.Networks, nil),
+		Name:             mc.Name,
+	})
+	if err != nil {
+		return fmt.Errorf("creating init container %s: %w", m.Name, err)
+	}
+	defer d.Client.ContainerRemove(ctx, resp.ID, client.ContainerRemoveOptions{RemoveVolumes: true, Force: true})
+
+	timeout := InitTimeout
+	if m.Timeout > 0 {
+		timeout = time.Duration(m.Timeout) * time.Second
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	log.Printf("Running init container %s of %s \n", m.Name, c.Name)
+	wait := d.Client.ContainerWait(waitCtx, resp.ID, client.ContainerWaitOptions{Condition: container.WaitConditionNextExit})
+
+	_, err = d.Client.ContainerStart(ctx, resp.ID, client.ContainerStartOptions{})
+	if err != nil {
+		return fmt.Errorf("starting init container %s: %w", m.Name, err)
+	}
+
+	// the deferred remove kills an init container that ran out of time
+	select {
+	case err = <-wait.Error:
+		if waitCtx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("init container %s did not finish within %v", m.Name, timeout)
+		}
+		return fmt.Errorf("waiting for init container %s: %w", m.Name, err)
+	case result := <-wait.Result:
+		if result.Error != nil {
+			return fmt.Errorf("init container %s failed: %s", m.Name, result.Error.Message)
+		}
+		if result.StatusCode != 0 {
+			return fmt.Errorf("init container %s exited with code %d", m.Name, result.StatusCode)
+		}
+	}
+
+	return nil
+}
+
+// startSidecar starts a sidecar in the network namespace of the main container
+func (d *Docker) startSidecar(ctx context.Context, c Config, mainID string, m Container) error {
+	mc := c.memberConfig(m)
+
+	err := d.pullImage(ctx, mc)
+	if err != nil {
+		return err
+	}
+
+	cc := container.Config{
+		Image:      mc.Image,
+		Cmd:        mc.Cmd,
+		WorkingDir: mc.WorkingDir,
+		Env:        mc.Env,
+		Labels:     map[string]string{groupLabel: mainID, memberLabel: m.Name},
+	}
+	hc := container.HostConfig{
+		NetworkMode: container.NetworkMode("container:" + mainID),
+		Mounts:      dockerMounts(mc.Mounts),
+	}
+	err = applySecurity(&cc, &hc, mc.Security)
+	if err != nil {
+		return fmt.Errorf("sidecar %s: %w", m.Name, err)
+	}
+
+	resp, err := d.Client.ContainerCreate(ctx, client.ContainerCreateOptions{
+		Config:     &cc,
+		HostConfig: &hc,
+		Name:       mc.Name,
+	})
+	if err != nil {
+		return fmt.Errorf("creating sidecar %s: %w", m.Name, err)
+	}
+
+	_, err = d.Client.ContainerStart(ctx, resp.ID, client.ContainerStartOptions{})
+	if err != nil {
+		return fmt.Errorf("starting sidecar %s: %w", m.Name, err)
+	}
+
+	return nil
+}
+
+// sidecars lists the sidecar containers of a group by member name, stopped ones included
+func (d *Docker) sidecars(ctx context.Context, mainID string) ([]container.Summary, error) {
+	resp, err := d.Client.ContainerList(ctx, client.ContainerListOptions{
+		All:     true,
+		Filters: make(client.Filters).Add("label", groupLabel+"="+mainID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(resp.Items, func(i, j int) bool {
+		return resp.Items[i].Labels[memberLabel] < resp.Items[j].Labels[memberLabel]
+	})
+	return resp.Items, nil
+}
+
+func (d *Docker) inspectSidecars(ctx context.Context, mainID string) ([]MemberStatus, error) {
+	items, err := d.sidecars(ctx, mainID)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []MemberStatus{}
+	for _, item := range items {
+		resp, err := d.Client.ContainerInspect(ctx, item.ID, client.ContainerInspectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		m := MemberStatus{
+			Name:        item.Labels[memberLabel],
+			ContainerID: item.ID,
+		}
+		if st := resp.Container.State; st != nil {
+			m.Running = st.Running
+			m.ExitCode = st.ExitCode
+			m.Error = st.Error
+		}
+		members = append(members, m)
+	}
+
+	return members, nil
+}
+
+// removeSidecars stops and removes every sidecar of a group, with the stop
+// options of the main container
+func (d *Docker) removeSidecars(ctx context.Context, mainID string, opts client.ContainerStopOptions) {
+	items, err := d.sidecars(ctx, mainID)
+	if err != nil {
+		log.Printf("Error listing sidecars of %s: %v\n", mainID, err)
+		return
+	}
+
+	for _, item := range items {
+		_, err = d.Client.ContainerStop(ctx, item.ID, opts)
+		if err != nil {
+			log.Printf("Error stopping sidecar %s: %v\n", item.ID, err)
+		}
+		_, err = d.Client.ContainerRemove(ctx, item.ID, client.ContainerRemoveOptions{RemoveVolumes: true, Force: true})
+		if err != nil {
+			log.Printf("Error removing sidecar %s: %v\n", item.ID, err)
+		}
+	}
+}
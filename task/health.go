@@ -0,0 +1,61 @@
+package task
+
+import (
+	"time"
+
+	"github.com/moby/moby/api/types/container"
+)
+
+// health of a task with a HealthCheck, as its driver last reported it
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// HealthCheck is a command run inside a task at an interval. The task is
+// healthy once it exits 0, and unhealthy after Retries failures in a row.
+type HealthCheck struct {
+	Cmd         []string
+	Interval    int //seconds between checks, 10 when 0
+	Timeout     int //seconds a check may take, 5 when 0
+	Retries     int //failures in a row before the task is unhealthy, 3 when 0
+	StartPeriod int //seconds after the start during which failures don't count
+}
+
+func validateHealthCheck(v *ValidationError, h *HealthCheck) {
+	if h == nil {
+		return
+	}
+	if len(h.Cmd) == 0 {
+		v.add("HealthCheck.Cmd", "is required")
+	}
+	if h.Interval < 0 || h.Timeout < 0 || h.Retries < 0 || h.StartPeriod < 0 {
+		v.add("HealthCheck", "Interval, Timeout, Retries and StartPeriod must not be negative")
+	}
+}
+
+// dockerHealthCheck turns a health check into the container's, nil leaves the image's own
+func dockerHealthCheck(h *HealthCheck) *container.HealthConfig {
+	if h == nil {
+		return nil
+	}
+	seconds := func(n int, def int) time.Duration {
+		if n == 0 {
+			n = def
+		}
+		return time.Duration(n) * time.Second
+	}
+	retries := h.Retries
+	if retries == 0 {
+		retries = 3
+	}
+
+	return &container.HealthConfig{
+		Test:        append([]string{"CMD"}, h.Cmd...),
+		Interval:    seconds(h.Interval, 10),
+		Timeout:     seconds(h.Timeout, 5),
+		StartPeriod: time.Duration(h.StartPeriod) * time.Second,
+		Retries:     retries,
+	}
+}
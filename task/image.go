@@ -0,0 +1,105 @@
+package task
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/moby/moby/api/types/registry"
+	"github.com/moby/moby/client"
+)
+
+type PullPolicy string
+
+const (
+	PullAlways       PullPolicy = "Always"
+	PullIfNotPresent PullPolicy = "IfNotPresent"
+	PullNever        PullPolicy = "Never"
+)
+
+type RegistryAuth struct {
+	ServerAddress string
+	Username      string
+	Password      string
+	IdentityToken string
+}
+
+// RegistryHost returns the registry an image is pulled from, e.g. docker.io for library images
+func RegistryHost(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return ""
+	}
+	return reference.Domain(named)
+}
+
+func (a *RegistryAuth) encode() (string, error) {
+	data, err := json.Marshal(registry.AuthConfig{
+		Username:      a.Username,
+		Password:      a.Password,
+		ServerAddress: a.ServerAddress,
+		IdentityToken: a.IdentityToken,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+func (d *Docker) imagePresent(ctx context.Context, c Config) (bool, error) {
+	_, err := d.Client.ImageInspect(ctx, c.Image)
+	if err == nil {
+		return true, nil
+	}
+	if errdefs.IsNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// pullImage makes the task's image available according to its pull policy
+func (d *Docker) pullImage(ctx context.Context, c Config) error {
+	policy := c.PullPolicy
+	if policy == "" {
+		policy = PullAlways
+	}
+
+	if policy != PullAlways {
+		present, err := d.imagePresent(ctx, c)
+		if err != nil {
+			return fmt.Errorf("inspecting image %s: %w", c.Image, err)
+		}
+		if present {
+			return nil
+		}
+		if policy == PullNever {
+			return fmt.Errorf("image %s not present on worker and pull policy is %s", c.Image, PullNever)
+		}
+	}
+
+	opts := client.ImagePullOptions{}
+	if c.RegistryAuth != nil {
+		auth, err := c.RegistryAuth.encode()
+		if err != nil {
+			return fmt.Errorf("encoding registry credentials: %w", err)
+		}
+		opts.RegistryAuth = auth
+	}
+
+	log.Printf("Pulling image %s \n", c.Image)
+	resp, err := d.Client.ImagePull(ctx, c.Image, opts)
+	if err != nil {
+		return fmt.Errorf("pulling image %s: %w", c.Image, err)
+	}
+
+	err = resp.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("pulling image %s: %w", c.Image, err)
+	}
+
+	return nil
+}
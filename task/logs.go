@@ -0,0 +1,163 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// lines kept per task by drivers that capture output themselves
+const maxLogLines = 10000
+
+type LogOptions struct {
+	Tail       int       //only the last Tail lines, 0 means all
+	Since      time.Time //only lines written after Since
+	Timestamps bool      //prefix every line with its RFC3339 timestamp
+	Follow     bool      //keep streaming new lines until the task exits
+}
+
+type logLine struct {
+	time time.Time
+	text []byte
+}
+
+// logBuffer keeps the recent output of one task split into timestamped lines
+type logBuffer struct {
+	mu      sync.Mutex
+	lines   []logLine
+	first   int //position of lines[0] in the task's whole output, grows as old lines are dropped
+	partial []byte
+	closed  bool
+	notify  chan struct{} //closed and replaced whenever lines are added or the buffer is closed
+}
+
+func newLogBuffer() *logBuffer {
+	return &logBuffer{notify: make(chan struct{})}
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now().UTC()
+	data := append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		b.lines = append(b.lines, logLine{time: now, text: bytes.Clone(data[:i+1])})
+		data = data[i+1:]
+	}
+	b.partial = bytes.Clone(data)
+
+	if len(b.lines) > maxLogLines {
+		b.first += len(b.lines) - maxLogLines
+		b.lines = b.lines[len(b.lines)-maxLogLines:]
+	}
+	b.wake()
+
+	return len(p), nil
+}
+
+// Close marks the end of the output, flushing an unterminated last line
+func (b *logBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.partial) > 0 {
+		b.lines = append(b.lines, logLine{time: time.Now().UTC(), text: append(b.partial, '\n')})
+		b.partial = nil
+	}
+	b.closed = true
+	b.wake()
+
+	return nil
+}
+
+func (b *logBuffer) wake() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// Reader streams the buffered lines, following new ones until the reader is
+// closed or ctx is done when opts.Follow is set
+func (b *logBuffer) Reader(ctx context.Context, opts LogOptions) io.ReadCloser {
+	pr, pw := io.Pipe()
+	stop := make(chan struct{})
+
+	go func() {
+		b.mu.Lock()
+		start := b.first
+		if opts.Tail > 0 && len(b.lines) > opts.Tail {
+			start = b.first + len(b.lines) - opts.Tail
+		}
+		b.mu.Unlock()
+
+		var err error
+		start, err = b.copyLines(pw, start, opts)
+		for err == nil && opts.Follow {
+			b.mu.Lock()
+			notify, closed, n := b.notify, b.closed, b.first+len(b.lines)
+			b.mu.Unlock()
+			if closed && start >= n {
+				break
+			}
+			if start >= n {
+				select {
+				case <-notify:
+				case <-stop:
+					err = io.ErrClosedPipe
+					continue
+				case <-ctx.Done():
+					err = ctx.Err()
+					continue
+				}
+			}
+			start, err = b.copyLines(pw, start, opts)
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return &logReader{PipeReader: pr, stop: stop}
+}
+
+// copyLines writes the lines from position start on and returns where to continue
+func (b *logBuffer) copyLines(w io.Writer, start int, opts LogOptions) (int, error) {
+	b.mu.Lock()
+	// lines a slow reader had not reached yet may have been dropped meanwhile
+	start = max(start, b.first)
+	lines := b.lines[start-b.first:]
+	b.mu.Unlock()
+
+	for _, l := range lines {
+		if !opts.Since.IsZero() && !l.time.After(opts.Since) {
+			continue
+		}
+		if opts.Timestamps {
+			_, err := io.WriteString(w, l.time.Format(time.RFC3339Nano)+" ")
+			if err != nil {
+				return start, err
+			}
+		}
+		_, err := w.Write(l.text)
+		if err != nil {
+			return start, err
+		}
+	}
+
+	return start + len(lines), nil
+}
+
+type logReader struct {
+	*io.PipeReader
+	stop chan struct{}
+	once sync.Once
+}
+
+func (r *logReader) Close() error {
+	r.once.Do(func() { close(r.stop) })
+	return r.PipeReader.Close()
+}
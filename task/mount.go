@@ -0,0 +1,57 @@
+package task
+
+import (
+	"github.com/moby/moby/api/types/mount"
+)
+
+type MountType string
+
+const (
+	MountBind   MountType = "bind"
+	MountVolume MountType = "volume"
+	MountTmpfs  MountType = "tmpfs"
+)
+
+type Mount struct {
+	Type       MountType
+	Source     string //host path for bind, volume name for volume, empty for tmpfs
+	Target     string //path inside the container
+	ReadOnly   bool
+	Persistent bool  //named volumes only: keep the volume when the task is stopped
+	TmpfsSize  int64 //tmpfs only: size in bytes, 0 means unlimited
+}
+
+func (m Mount) toDocker() mount.Mount {
+	dm := mount.Mount{
+		Type:     mount.Type(m.Type),
+		Source:   m.Source,
+		Target:   m.Target,
+		ReadOnly: m.ReadOnly,
+	}
+
+	if m.Type == MountTmpfs {
+		dm.Source = ""
+		dm.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: m.TmpfsSize}
+	}
+
+	return dm
+}
+
+func dockerMounts(mounts []Mount) []mount.Mount {
+	dms := []mount.Mount{}
+	for _, m := range mounts {
+		dms = append(dms, m.toDocker())
+	}
+	return dms
+}
+
+// scratchVolumes returns the named volumes that should be removed along with the container
+func scratchVolumes(mounts []Mount) []string {
+	names := []string{}
+	for _, m := range mounts {
+		if m.Type == MountVolume && m.Source != "" && !m.Persistent {
+			names = append(names, m.Source)
+		}
+	}
+	return names
+}
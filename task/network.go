@@ -0,0 +1,79 @@
+package task
+
+import (
+	"context"
+	"log"
+
+	"github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/network"
+	"github.com/moby/moby/client"
+)
+
+// networks created by a worker carry this label so only those get cleaned up
+const managedNetworkLabel = "goat.managed"
+
+func (d *Docker) EnsureNetwork(name string) error {
+	ctx := context.Background()
+
+	_, err := d.Client.NetworkInspect(ctx, name, client.NetworkInspectOptions{})
+	if err == nil {
+		return nil
+	}
+	if !errdefs.IsNotFound(err) {
+		return err
+	}
+
+	log.Printf("Creating network %s \n", name)
+	_, err = d.Client.NetworkCreate(ctx, name, client.NetworkCreateOptions{
+		Driver: "bridge",
+		Labels: map[string]string{managedNetworkLabel: "true"},
+	})
+	if err != nil && !errdefs.IsConflict(err) {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveNetwork deletes a worker created network once no container is attached to it
+func (d *Docker) RemoveNetwork(name string) error {
+	ctx := context.Background()
+
+	resp, err := d.Client.NetworkInspect(ctx, name, client.NetworkInspectOptions{})
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if resp.Network.Labels[managedNetworkLabel] != "true" || len(resp.Network.Containers) > 0 {
+		return nil
+	}
+
+	log.Printf("Removing network %s \n", name)
+	_, err = d.Client.NetworkRemove(ctx, name, client.NetworkRemoveOptions{})
+	return err
+}
+
+func (d *Docker) releaseNetworks(networks []string) {
+	for _, n := range networks {
+		err := d.RemoveNetwork(n)
+		if err != nil {
+			log.Printf("Error removing network %s: %v\n", n, err)
+		}
+	}
+}
+
+func networkingConfig(networks []string, aliases []string) *network.NetworkingConfig {
+	if len(networks) == 0 {
+		return nil
+	}
+
+	endpoints := make(map[string]*network.EndpointSettings)
+	for _, n := range networks {
+		endpoints[n] = &network.EndpointSettings{Aliases: aliases}
+	}
+
+	return &network.NetworkingConfig{EndpointsConfig: endpoints}
+}
@@ -0,0 +1,187 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/moby/moby/client"
+)
+
+// Pauser is implemented by drivers that can freeze a started task and restart it in place
+type Pauser interface {
+	Pause(id string) error
+	Unpause(id string) error
+	// Restart stops the task and starts it again, returning the ID it runs under afterwards
+	Restart(c Config) (string, error)
+}
+
+// Pause freezes the container of a task along with its sidecars
+func (d *Docker) Pause(id string) error {
+	ctx := context.Background()
+	ids, err := d.groupIDs(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, cid := range ids {
+		_, err = d.Client.ContainerPause(ctx, cid, client.ContainerPauseOptions{})
+		if err != nil {
+			return fmt.Errorf("pausing container %s: %w", cid, err)
+		}
+	}
+	return nil
+}
+
+// Unpause thaws the container of a task along with its sidecars
+func (d *Docker) Unpause(id string) error {
+	ctx := context.Background()
+	ids, err := d.groupIDs(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	for _, cid := range ids {
+		_, err = d.Client.ContainerUnpause(ctx, cid, client.ContainerUnpauseOptions{})
+		if err != nil {
+			return fmt.Errorf("unpausing container %s: %w", cid, err)
+		}
+	}
+	return nil
+}
+
+// Restart restarts the container of a task with its stop signal and grace
+// period, then its sidecars so they join the new network namespace
+func (d *Docker) Restart(c Config) (string, error) {
+	ctx := context.Background()
+	d.runPreStop(c)
+
+	_, err := d.Client.ContainerRestart(ctx, c.ContainerID, client.ContainerRestartOptions{
+		Signal:  c.StopSignal,
+		Timeout: c.StopGracePeriod,
+	})
+	if err != nil {
+		return "", fmt.Errorf("restarting container %s: %w", c.ContainerID, err)
+	}
+
+	ids, err := d.groupIDs(ctx, c.ContainerID)
+	if err != nil {
+		return "", err
+	}
+	for _, cid := range ids[1:] {
+		_, err = d.Client.ContainerRestart(ctx, cid, client.ContainerRestartOptions{})
+		if err != nil {
+			return "", fmt.Errorf("restarting sidecar %s: %w", cid, err)
+		}
+	}
+
+	return c.ContainerID, nil
+}
+
+// groupIDs returns the main container of a task followed by its sidecars
+func (d *Docker) groupIDs(ctx context.Context, mainID string) ([]string, error) {
+	items, err := d.sidecars(ctx, mainID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{mainID}
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids, nil
+}
+
+// Pause stops the process group of a task with SIGSTOP
+func (e *Exec) Pause(id string) error {
+	return e.signal(id, syscall.SIGSTOP)
+}
+
+// Unpause continues the process group of a task with SIGCONT
+func (e *Exec) Unpause(id string) error {
+	return e.signal(id, syscall.SIGCONT)
+}
+
+func (e *Exec) signal(id string, sig syscall.Signal) error {
+	p, err := e.process(id)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-p.done:
+		return fmt.Errorf("process %s has exited", id)
+	default:
+	}
+	return syscall.Kill(-p.cmd.Process.Pid, sig)
+}
+
+// Restart stops the process group of a task and runs the same command line
+// again, with the environment it was started with
+func (e *Exec) Restart(c Config) (string, error) {
+	p, err := e.process(c.ContainerID)
+	if err != nil {
+		return "", err
+	}
+	args := slices.Clone(p.cmd.Args)
+	env := slices.Clone(p.cmd.Env)
+	dir := p.cmd.Dir
+
+	result := e.Stop(c)
+	if result.Error != nil {
+		return "", result.Error
+	}
+
+	id, err := e.spawn(args[0], args[1:], env, dir)
+	if err != nil {
+		return "", fmt.Errorf("restarting %s: %w", args[0], err)
+	}
+	return id, nil
+}
+
+func (f *Fake) Pause(id string) error {
+	return f.setPaused(id, true)
+}
+
+func (f *Fake) Unpause(id string) error {
+	return f.setPaused(id, false)
+}
+
+func (f *Fake) setPaused(id string, paused bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[id]
+	if !ok {
+		return fmt.Errorf("no such container: %s", id)
+	}
+	if fc.stopped || fc.exited() {
+		return fmt.Errorf("container %s is not running", id)
+	}
+	if fc.paused == paused {
+		return fmt.Errorf("container %s is already in that state", id)
+	}
+	fc.paused = paused
+	return nil
+}
+
+// Restart starts a fake container over, it runs for the full RunTime again
+func (f *Fake) Restart(c Config) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fc, ok := f.containers[c.ContainerID]
+	if !ok {
+		return "", fmt.Errorf("no such container: %s", c.ContainerID)
+	}
+	fc.startedAt = time.Now().UTC()
+	fc.paused = false
+	fc.oomKilled = false
+	log.Printf("Restarted fake container %s \n", c.ContainerID)
+	fmt.Fprintf(fc.logs, "fake container %s restarted\n", fc.config.Name)
+
+	return c.ContainerID, nil
+}
@@ -0,0 +1,105 @@
+package task
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// SecretRef points a task at a secret stored on the manager. Exactly one of Env
+// and File says how the value is handed to the task.
+type SecretRef struct {
+	Name string
+	Env  string //environment variable to set to the value
+	File string //path inside the container the value is mounted at, from tmpfs
+}
+
+// host directory file secrets are written to before being bind mounted, on tmpfs so they never touch disk
+var SecretsDir = "/dev/shm/goat-secrets"
+
+func secretsDir(id uuid.UUID) string {
+	return filepath.Join(SecretsDir, id.String())
+}
+
+// prepareSecrets returns the env entries for env secrets and writes file secrets
+// out as read-only mounts. values holds the secret values by name.
+func prepareSecrets(c Config) ([]string, []Mount, error) {
+	env := []string{}
+	mounts := []Mount{}
+
+	for i, ref := range c.Secrets {
+		value, ok := c.SecretValues[ref.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("secret %s was not delivered with the task", ref.Name)
+		}
+
+		switch {
+		case ref.Env != "" && ref.File == "":
+			env = append(env, ref.Env+"="+value)
+		case ref.File != "" && ref.Env == "":
+			dir := secretsDir(c.TaskID)
+			err := os.MkdirAll(dir, 0700)
+			if err != nil {
+				return nil, nil, fmt.Errorf("creating secrets directory: %v", err)
+			}
+			// index based names keep secrets apart even if two refs share a base name
+			hostPath := filepath.Join(dir, fmt.Sprintf("%d", i))
+			err = writeSecretFile(hostPath, value, c.Security)
+			if err != nil {
+				return nil, nil, fmt.Errorf("writing secret %s: %v", ref.Name, err)
+			}
+			mounts = append(mounts, Mount{
+				Type:     MountBind,
+				Source:   hostPath,
+				Target:   ref.File,
+				ReadOnly: true,
+			})
+		default:
+			return nil, nil, fmt.Errorf("secret %s needs exactly one of Env and File", ref.Name)
+		}
+	}
+
+	return env, mounts, nil
+}
+
+// writeSecretFile writes a secret readable by the user the container runs as.
+// A numeric user gets the file for itself, a named one can't be resolved on the
+// host, so the file is made readable by everyone; the directory it sits in is
+// still closed to other host users.
+func writeSecretFile(path string, value string, s *Security) error {
+	err := os.WriteFile(path, []byte(value), 0400)
+	if err != nil {
+		return err
+	}
+	if s.RunsAsRoot() {
+		return nil
+	}
+
+	user, group, _ := strings.Cut(s.User, ":")
+	uid, err := strconv.Atoi(user)
+	if err == nil {
+		gid, gerr := strconv.Atoi(group)
+		if gerr != nil {
+			gid = -1
+		}
+		if os.Chown(path, uid, gid) == nil {
+			return nil
+		}
+	}
+	return os.Chmod(path, 0444)
+}
+
+func removeSecrets(c Config) {
+	if len(c.Secrets) == 0 {
+		return
+	}
+	err := os.RemoveAll(secretsDir(c.TaskID))
+	if err != nil {
+		log.Printf("Error removing secrets of task %v: %v\n", c.TaskID, err)
+	}
+}
@@ -0,0 +1,31 @@
+package task
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSecretFileMode(t *testing.T) {
+	for _, tc := range []struct {
+		security *Security
+		want     os.FileMode
+	}{
+		{nil, 0400},
+		{&Security{User: "root"}, 0400},
+		{&Security{User: "app"}, 0444},
+	} {
+		path := filepath.Join(t.TempDir(), "secret")
+		err := writeSecretFile(path, "value", tc.security)
+		if err != nil {
+			t.Fatalf("writing secret: %v", err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != tc.want {
+			t.Errorf("security %+v: got mode %v, want %v", tc.security, info.Mode().Perm(), tc.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package task
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/moby/moby/api/types/container"
+)
+
+// namespace of tasks that set none
+const DefaultNamespace = "default"
+
+// ProfileUnconfined turns a seccomp or AppArmor profile off
+const ProfileUnconfined = "unconfined"
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Security holds the security settings of a task, they apply to every container of its group
+type Security struct {
+	User            string //user, or user:group, to run as, by name or numeric ID
+	ReadOnlyRootfs  bool
+	CapAdd          []string //kernel capabilities to add, e.g. NET_ADMIN
+	CapDrop         []string //kernel capabilities to drop, ALL drops every one
+	NoNewPrivileges bool
+	SeccompProfile  string //path of a JSON profile on the worker, or unconfined. Docker's default when empty
+	AppArmorProfile string //name of a profile loaded on the worker, or unconfined
+	PidsLimit       int64  //processes the task may run at once, unlimited when 0
+}
+
+// NamespaceName returns the namespace a task belongs to
+func NamespaceName(t Task) string {
+	if t.Namespace == "" {
+		return DefaultNamespace
+	}
+	return t.Namespace
+}
+
+// RunsAsRoot reports settings that leave the container running as root
+func (s *Security) RunsAsRoot() bool {
+	if s == nil || s.User == "" {
+		return true
+	}
+	user, _, _ := strings.Cut(s.User, ":")
+	return user == "root" || user == "0"
+}
+
+func validateNamespace(v *ValidationError, namespace string) {
+	if namespace != "" && !namespacePattern.MatchString(namespace) {
+		v.add("Namespace", "must be lowercase letters, digits and dashes")
+	}
+}
+
+func validateSecurity(v *ValidationError, s *Security) {
+	if s == nil {
+		return
+	}
+
+	if s.User != "" {
+		user, group, found := strings.Cut(s.User, ":")
+		if user == "" || (found && group == "") {
+			v.add("Security.User", "must be user or user:group")
+		}
+	}
+	validateCapabilities(v, "Security.CapAdd", s.CapAdd)
+	validateCapabilities(v, "Security.CapDrop", s.CapDrop)
+	if s.SeccompProfile != "" && s.SeccompProfile != ProfileUnconfined && !path.IsAbs(s.SeccompProfile) {
+		v.add("Security.SeccompProfile", "must be an absolute path or %s", ProfileUnconfined)
+	}
+	if strings.ContainsAny(s.AppArmorProfile, " =") {
+		v.add("Security.AppArmorProfile", "invalid profile %q", s.AppArmorProfile)
+	}
+	if s.PidsLimit < 0 {
+		v.add("Security.PidsLimit", "must not be negative")
+	}
+}
+
+func validateCapabilities(v *ValidationError, field string, caps []string) {
+	for i, c := range caps {
+		if c == "" || strings.ContainsAny(c, " =") {
+			v.add(fmt.Sprintf("%s[%d]", field, i), "invalid capability %q", c)
+		}
+	}
+}
+
+// applySecurity sets the security settings of a task on a container about to be created
+func applySecurity(cc *container.Config, hc *container.HostConfig, s *Security) error {
+	if s == nil {
+		return nil
+	}
+
+	cc.User = s.User
+	hc.ReadonlyRootfs = s.ReadOnlyRootfs
+	hc.CapAdd = s.CapAdd
+	hc.CapDrop = s.CapDrop
+	if s.NoNewPrivileges {
+		hc.SecurityOpt = append(hc.SecurityOpt, "no-new-privileges:true")
+	}
+	switch s.SeccompProfile {
+	case "":
+	case ProfileUnconfined:
+		hc.SecurityOpt = append(hc.SecurityOpt, "seccomp="+ProfileUnconfined)
+	default:
+		// the API takes the profile itself rather than its path
+		profile, err := os.ReadFile(s.SeccompProfile)
+		if err != nil {
+			return fmt.Errorf("reading seccomp profile: %w", err)
+		}
+		hc.SecurityOpt = append(hc.SecurityOpt, "seccomp="+string(profile))
+	}
+	if s.AppArmorProfile != "" {
+		hc.SecurityOpt = append(hc.SecurityOpt, "apparmor="+s.AppArmorProfile)
+	}
+	if s.PidsLimit > 0 {
+		limit := s.PidsLimit
+		hc.Resources.PidsLimit = &limit
+	}
+
+	return nil
+}
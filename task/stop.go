@@ -0,0 +1,196 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/moby/moby/client"
+)
+
+// how long a pre-stop hook may take when it sets no Timeout
+const defaultHookTimeout = 30
+
+// PreStopHook runs before a task is sent its stop signal, e.g. to drain
+// connections. Set either Cmd or HTTPGet.
+type PreStopHook struct {
+	Cmd     []string //command run inside the task
+	HTTPGet string   //path requested from the task on Port
+	Port    int
+	Timeout int //seconds, 30 when 0
+}
+
+// StopOptions override how a single stop request treats a task
+type StopOptions struct {
+	GracePeriod *int //seconds between the stop signal and SIGKILL, replaces the task's StopGracePeriod
+	Force       bool //kill right away, skipping the pre-stop hook
+}
+
+func (h *PreStopHook) timeout() time.Duration {
+	if h.Timeout <= 0 {
+		return defaultHookTimeout * time.Second
+	}
+	return time.Duration(h.Timeout) * time.Second
+}
+
+// ApplyStopOptions applies the overrides of a stop request to the config
+func (c *Config) ApplyStopOptions(opts *StopOptions) {
+	if opts == nil {
+		return
+	}
+	if opts.GracePeriod != nil {
+		grace := *opts.GracePeriod
+		c.StopGracePeriod = &grace
+	}
+	c.ForceStop = opts.Force
+}
+
+// ParseStopOptions reads the grace and force query parameters of a stop request
+func ParseStopOptions(q url.Values) (*StopOptions, error) {
+	if !q.Has("grace") && !q.Has("force") {
+		return nil, nil
+	}
+
+	opts := &StopOptions{}
+	if v := q.Get("grace"); v != "" {
+		grace, err := strconv.Atoi(v)
+		if err != nil || grace < 0 {
+			return nil, fmt.Errorf("invalid grace: %q", v)
+		}
+		opts.GracePeriod = &grace
+	}
+	if v := q.Get("force"); v != "" {
+		force, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid force: %q", v)
+		}
+		opts.Force = force
+	}
+
+	return opts, nil
+}
+
+// stopSignal parses signal names like SIGTERM, TERM or 15
+func stopSignal(name string) (syscall.Signal, error) {
+	if name == "" {
+		return syscall.SIGTERM, nil
+	}
+
+	if n, err := strconv.Atoi(name); err == nil {
+		// 0 would only probe the process, real-time signals are not meant for stopping
+		if n < 1 || n > 31 {
+			return 0, fmt.Errorf("signal %d is outside 1-31", n)
+		}
+		return syscall.Signal(n), nil
+	}
+
+	signals := map[string]syscall.Signal{
+		"HUP": syscall.SIGHUP, "INT": syscall.SIGINT, "QUIT": syscall.SIGQUIT, "KILL": syscall.SIGKILL,
+		"USR1": syscall.SIGUSR1, "USR2": syscall.SIGUSR2, "TERM": syscall.SIGTERM,
+	}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %s", name)
+	}
+	return sig, nil
+}
+
+// httpHook requests the hook's path from host, failing on a non 2xx answer
+func httpHook(ctx context.Context, h *PreStopHook, host string) error {
+	url := fmt.Sprintf("http://%s:%d/%s", host, h.Port, strings.TrimPrefix(h.HTTPGet, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// runPreStop runs a container's pre-stop hook. A failing hook is logged and does not block the stop.
+func (d *Docker) runPreStop(c Config) {
+	h := c.PreStop
+	if h == nil || c.ForceStop {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout())
+	defer cancel()
+
+	resp, err := d.Client.ContainerInspect(ctx, c.ContainerID, client.ContainerInspectOptions{})
+	if err != nil || resp.Container.State == nil || !resp.Container.State.Running {
+		// nothing to drain in a container that already exited
+		return
+	}
+
+	if len(h.Cmd) > 0 {
+		err = d.execHook(ctx, c.ContainerID, h.Cmd)
+	} else {
+		err = fmt.Errorf("container %s has no IP address", c.ContainerID)
+		if ns := resp.Container.NetworkSettings; ns != nil {
+			for _, ep := range ns.Networks {
+				if ep != nil && ep.IPAddress.IsValid() {
+					err = httpHook(ctx, h, ep.IPAddress.String())
+					break
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		log.Printf("Pre-stop hook of %s failed: %v\n", c.Name, err)
+	}
+}
+
+func (d *Docker) execHook(ctx context.Context, id string, cmd []string) error {
+	result, err := d.Exec(ctx, id, ExecRequest{Cmd: cmd})
+	if ctx.Err() != nil {
+		return fmt.Errorf("%v timed out", cmd)
+	}
+	if err == nil && result.ExitCode != 0 {
+		err = fmt.Errorf("%v exited with code %d", cmd, result.ExitCode)
+	}
+	return err
+}
+
+// runPreStop runs a process's pre-stop hook on the host, next to the process
+func (e *Exec) runPreStop(c Config) {
+	h := c.PreStop
+	if h == nil || c.ForceStop {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout())
+	defer cancel()
+
+	var err error
+	if len(h.Cmd) > 0 {
+		cmd := exec.CommandContext(ctx, h.Cmd[0], h.Cmd[1:]...)
+		// like the task itself, the hook does not see the worker's environment
+		cmd.Env = append(slices.Clone(ExecBaseEnv), c.Env...)
+		cmd.Dir = c.WorkingDir
+		err = cmd.Run()
+	} else {
+		err = httpHook(ctx, h, "127.0.0.1")
+	}
+
+	if err != nil {
+		log.Printf("Pre-stop hook of %s failed: %v\n", c.Name, err)
+	}
+}
@@ -0,0 +1,27 @@
+package task
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestStopSignal(t *testing.T) {
+	for name, want := range map[string]syscall.Signal{
+		"":       syscall.SIGTERM,
+		"SIGINT": syscall.SIGINT,
+		"quit":   syscall.SIGQUIT,
+		"9":      syscall.SIGKILL,
+		"31":     syscall.Signal(31),
+	} {
+		got, err := stopSignal(name)
+		if err != nil || got != want {
+			t.Errorf("stopSignal(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+
+	for _, name := range []string{"0", "-9", "32", "64", "15abc", "SIGNOPE"} {
+		if _, err := stopSignal(name); err == nil {
+			t.Errorf("stopSignal(%q) accepted an invalid signal", name)
+		}
+	}
+}
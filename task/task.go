@@ -2,13 +2,11 @@ package task
 
 import (
 	"context"
-	"io"
+	"fmt"
 	"log"
 	"math"
-	"os"
 	"time"
 
-	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/google/uuid"
 	"github.com/moby/moby/api/types/container"
 	"github.com/moby/moby/api/types/network"
@@ -23,12 +21,14 @@ const (
 	Running
 	Completed
 	Failed
+	Paused //frozen through the API, resumes as Running
 )
 
 var stateTransitionMap = map[State][]State{
 	Pending:   []State{Scheduled},
 	Scheduled: []State{Scheduled, Running, Failed},
-	Running:   []State{Running, Completed, Failed},
+	Running:   []State{Running, Paused, Completed, Failed},
+	Paused:    []State{Paused, Running, Completed, Failed},
 	Completed: []State{},
 	Failed:    []State{},
 }
@@ -47,51 +47,208 @@ func ValidaStateTransition(src State, dst State) bool {
 }
 
 type Task struct {
-	ID            uuid.UUID
-	ContainerID   string
-	Name          string
-	State         State
-	Image         string
-	Memory        int //required memory
-	Disk          int //required disk space
-	ExposedPorts  network.PortSet
-	PortBindings  map[string]string
-	RestartPolicy string
-	StartTime     time.Time
-	FinishTime    time.Time
+	ID              uuid.UUID
+	ContainerID     string
+	Name            string
+	Namespace       string //groups tasks for security policies, default when empty
+	State           State
+	Type            string //batch or service, service when empty
+	Service         string //name of the service that started the task, if any
+	Version         int    //version of the service template the task was started from
+	Job             string //ID of the job that started the task, if any
+	Driver          string //runtime driver the task needs, docker when empty
+	Image           string
+	PullPolicy      PullPolicy
+	Cmd             []string //overrides the image command, or the executable and its args for the exec driver
+	Env             []string
+	WorkingDir      string
+	Memory          int //required memory
+	Disk            int //required disk space
+	ExposedPorts    network.PortSet
+	PortBindings    map[string]string
+	RestartPolicy   string
+	MaxRuntime      int    //seconds the task may run before it is stopped, 0 means no limit
+	StopSignal      string //signal asking the task to stop, SIGTERM when empty
+	StopGracePeriod int    //seconds between the stop signal and SIGKILL, the driver default when 0
+	PreStop         *PreStopHook
+	HealthCheck     *HealthCheck
+	Mounts          []Mount
+	Networks        []string //user defined networks, created on the worker if missing
+	NetworkAliases  []string //DNS names of the task on each of its networks
+	Secrets         []SecretRef
+	Security        *Security
+	InitContainers  []Container    //run to completion before the main container starts
+	Sidecars        []Container    //run next to the main container, sharing its network namespace
+	Members         []MemberStatus //sidecars of a started group, as last inspected
+	Health          string         //of a task with a HealthCheck, as last inspected
+	StartTime       time.Time
+	FinishTime      time.Time
+	ExitCode        int
+	OOMKilled       bool
+	Error           string //why the task failed, from the driver or the runtime
+	FinishReason    string
+}
+
+const (
+	TypeService = "service" //expected to run until stopped, exiting on its own is a failure
+	TypeBatch   = "batch"   //runs to completion, its exit code decides Completed or Failed
+)
+
+// reasons a task stopped running
+const (
+	ReasonExited      = "Exited"      //the process ended on its own
+	ReasonOOMKilled   = "OOMKilled"   //killed for exceeding its memory limit
+	ReasonStartFailed = "StartFailed" //never got running, e.g. image pull or container create failed
+	ReasonStopped     = "Stopped"     //stopped through the API
+
+	ReasonDeadlineExceeded = "DeadlineExceeded" //ran longer than its MaxRuntime
+	ReasonLost             = "Lost"             //its worker stopped reporting it
+)
+
+// Spec returns the task as submitted, without its identity and runtime state
+func (t Task) Spec() Task {
+	return Task{
+		Name:            t.Name,
+		Namespace:       t.Namespace,
+		Type:            t.Type,
+		Driver:          t.Driver,
+		Image:           t.Image,
+		PullPolicy:      t.PullPolicy,
+		Cmd:             t.Cmd,
+		Env:             t.Env,
+		WorkingDir:      t.WorkingDir,
+		Memory:          t.Memory,
+		Disk:            t.Disk,
+		ExposedPorts:    t.ExposedPorts,
+		PortBindings:    t.PortBindings,
+		RestartPolicy:   t.RestartPolicy,
+		MaxRuntime:      t.MaxRuntime,
+		StopSignal:      t.StopSignal,
+		StopGracePeriod: t.StopGracePeriod,
+		PreStop:         t.PreStop,
+		HealthCheck:     t.HealthCheck,
+		Security:        t.Security,
+		Mounts:          t.Mounts,
+		Networks:        t.Networks,
+		NetworkAliases:  t.NetworkAliases,
+		Secrets:         t.Secrets,
+		InitContainers:  t.InitContainers,
+		Sidecars:        t.Sidecars,
+	}
+}
+
+// RecordExit copies how the task's process ended from the driver's view of it
+func (t *Task) RecordExit(s Status) {
+	t.ExitCode = s.ExitCode
+	t.OOMKilled = s.OOMKilled
+	t.Error = s.Error
+	t.Members = s.Members
+	t.FinishTime = s.FinishedAt
+	if t.FinishTime.IsZero() {
+		t.FinishTime = time.Now().UTC()
+	}
+
+	t.FinishReason = ReasonExited
+	if s.OOMKilled {
+		t.FinishReason = ReasonOOMKilled
+	}
+	if t.Error == "" && s.ExitState() == Failed {
+		t.Error = fmt.Sprintf("exited with code %d", s.ExitCode)
+	}
+}
+
+// StateAfterExit is the state a task moves to when its process ends on its own
+func (t *Task) StateAfterExit(s Status) State {
+	if t.Type == TypeBatch {
+		return s.ExitState()
+	}
+	return Failed
+}
+
+// Deadline returns when a started task with a MaxRuntime has to be stopped
+func (t *Task) Deadline() (time.Time, bool) {
+	if t.MaxRuntime <= 0 || t.StartTime.IsZero() {
+		return time.Time{}, false
+	}
+	return t.StartTime.Add(time.Duration(t.MaxRuntime) * time.Second), true
+}
+
+// RecordStartFailure marks why the task could not be started
+func (t *Task) RecordStartFailure(err error) {
+	t.Error = err.Error()
+	t.FinishReason = ReasonStartFailed
+	t.FinishTime = time.Now().UTC()
 }
 
 type Config struct {
-	Name          string
-	ContainerID   string
-	AttachStdin   bool
-	AttachStdout  bool
-	AttachStderr  bool
-	ExposedPorts  network.PortSet
-	Cmd           []string
-	Image         string
-	Cpu           float64
-	Memory        int64
-	Disk          int64
-	Env           []string
-	RestartPolicy string
+	TaskID          uuid.UUID
+	Name            string
+	ContainerID     string
+	Driver          string
+	AttachStdin     bool
+	AttachStdout    bool
+	AttachStderr    bool
+	ExposedPorts    network.PortSet
+	Cmd             []string
+	WorkingDir      string
+	Image           string
+	PullPolicy      PullPolicy
+	RegistryAuth    *RegistryAuth
+	Cpu             float64
+	Memory          int64
+	Disk            int64
+	Env             []string
+	RestartPolicy   string
+	Mounts          []Mount
+	Networks        []string
+	NetworkAliases  []string
+	Secrets         []SecretRef
+	InitContainers  []Container
+	Sidecars        []Container
+	StopSignal      string
+	StopGracePeriod *int //nil leaves the driver default
+	PreStop         *PreStopHook
+	HealthCheck     *HealthCheck
+	Security        *Security
+	ForceStop       bool
+	SecretValues    map[string]string //resolved values by secret name, never persisted
 }
 
 func NewConfig(task *Task) Config {
-	return Config{
-		Name:          task.Name,
-		ContainerID:   task.ContainerID,
-		ExposedPorts:  task.ExposedPorts,
-		Image:         task.Image,
-		Memory:        int64(task.Memory),
-		Disk:          int64(task.Disk),
-		RestartPolicy: task.RestartPolicy,
+	c := Config{
+		TaskID:         task.ID,
+		Name:           task.Name,
+		ContainerID:    task.ContainerID,
+		Driver:         task.Driver,
+		ExposedPorts:   task.ExposedPorts,
+		Image:          task.Image,
+		PullPolicy:     task.PullPolicy,
+		Cmd:            task.Cmd,
+		Env:            task.Env,
+		WorkingDir:     task.WorkingDir,
+		Memory:         int64(task.Memory),
+		Disk:           int64(task.Disk),
+		RestartPolicy:  task.RestartPolicy,
+		Mounts:         task.Mounts,
+		Networks:       task.Networks,
+		NetworkAliases: task.NetworkAliases,
+		Secrets:        task.Secrets,
+		InitContainers: task.InitContainers,
+		Sidecars:       task.Sidecars,
+		StopSignal:     task.StopSignal,
+		PreStop:        task.PreStop,
+		HealthCheck:    task.HealthCheck,
+		Security:       task.Security,
 	}
+	if task.StopGracePeriod > 0 {
+		grace := task.StopGracePeriod
+		c.StopGracePeriod = &grace
+	}
+	return c
 }
 
 type Docker struct {
-	Client client.Client
-	Config Config
+	Client *client.Client
 }
 
 type DockerResult struct {
@@ -101,98 +258,128 @@ type DockerResult struct {
 	Result      string
 }
 
-func NewDocker(conf Config) *Docker {
-	new_client, err := client.New()
-	if err != nil || new_client == nil {
-		log.Println("Docker daemon unreachable")
+func NewDocker() (*Docker, error) {
+	new_client, err := client.New(client.FromEnv)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Docker{
-		Client: *new_client,
-		Config: conf,
-	}
+		Client: new_client,
+	}, nil
+}
+
+func (d *Docker) Name() string {
+	return DriverDocker
 }
 
-func (d *Docker) Run() DockerResult {
+func (d *Docker) Start(c Config) DockerResult {
 	ctx := context.Background()
 
-	reader, err := d.Client.ImagePull(
-		ctx,
-		d.Config.Image,
-		client.ImagePullOptions{},
-	)
+	err := d.pullImage(ctx, c)
 	if err != nil {
-		log.Printf("Error Pulling image: %s: %v\n", d.Config.Image, err)
+		log.Printf("Error pulling image: %s: %v\n", c.Image, err)
+		return DockerResult{Error: err}
 	}
 
-	io.Copy(os.Stdout, reader)
-
 	rp := container.RestartPolicy{
-		Name: container.RestartPolicyMode(d.Config.RestartPolicy),
+		Name: container.RestartPolicyMode(c.RestartPolicy),
 	}
 
 	r := container.Resources{
-		Memory:   d.Config.Memory,
-		NanoCPUs: int64(d.Config.Cpu * math.Pow(10, 9)),
+		Memory:   c.Memory,
+		NanoCPUs: int64(c.Cpu * math.Pow(10, 9)),
+	}
+
+	secretEnv, secretMounts, err := prepareSecrets(c)
+	if err != nil {
+		log.Printf("Error preparing secrets of task %s: %v\n", c.Name, err)
+		removeSecrets(c)
+		return DockerResult{Error: err}
 	}
 
 	cc := container.Config{
-		Image:        d.Config.Image,
+		Image:        c.Image,
+		Cmd:          c.Cmd,
+		WorkingDir:   c.WorkingDir,
 		Tty:          false,
-		Env:          d.Config.Env,
-		ExposedPorts: d.Config.ExposedPorts,
+		Env:          append(append([]string{}, c.Env...), secretEnv...),
+		ExposedPorts: c.ExposedPorts,
+		StopSignal:   c.StopSignal,
+		Healthcheck:  dockerHealthCheck(c.HealthCheck),
 	}
 
 	hc := container.HostConfig{
 		RestartPolicy:   rp,
 		Resources:       r,
 		PublishAllPorts: true,
+		Mounts:          dockerMounts(append(append([]Mount{}, c.Mounts...), secretMounts...)),
+	}
+	err = applySecurity(&cc, &hc, c.Security)
+	if err != nil {
+		log.Printf("Error applying security settings of task %s: %v\n", c.Name, err)
+		removeSecrets(c)
+		return DockerResult{Error: err}
 	}
 
-	// &cc,
-	// 	&hc,
-	// 	nil,
-	// 	nil,
-	// 	d.Config.Name
+	for _, n := range c.Networks {
+		err = d.EnsureNetwork(n)
+		if err != nil {
+			log.Printf("error creating network %s: %v \n", n, err)
+			removeSecrets(c)
+			return DockerResult{Error: err}
+		}
+	}
+
+	for _, m := range c.InitContainers {
+		err = d.runInit(ctx, c, m)
+		if err != nil {
+			log.Printf("Error running init containers of %s: %v\n", c.Name, err)
+			d.releaseNetworks(c.Networks)
+			removeSecrets(c)
+			return DockerResult{Error: err}
+		}
+	}
 
 	resp, err := d.Client.ContainerCreate(
 		ctx,
 		client.ContainerCreateOptions{
 			Config:           &cc,
 			HostConfig:       &hc,
-			NetworkingConfig: nil,
+			NetworkingConfig: networkingConfig(c.Networks, c.NetworkAliases),
 			Platform:         nil,
-			Name:             d.Config.Name,
+			Name:             c.Name,
 		},
 	)
 
 	if err != nil {
-		log.Printf("error creating the container using image: %s, %v \n", d.Config.Image, err)
+		log.Printf("error creating the container using image: %s, %v \n", c.Image, err)
+		d.releaseNetworks(c.Networks)
+		removeSecrets(c)
 		return DockerResult{Error: err}
 	}
 
 	_, err = d.Client.ContainerStart(ctx, resp.ID, client.ContainerStartOptions{})
 	if err != nil {
 		log.Printf("error starting the container. ID: %s, %v \n", resp.ID, err)
+		_, rerr := d.Client.ContainerRemove(ctx, resp.ID, client.ContainerRemoveOptions{RemoveVolumes: true, Force: true})
+		if rerr != nil {
+			log.Printf("Error removing container %s: %v\n", resp.ID, rerr)
+		}
+		d.cleanup(ctx, c)
 		return DockerResult{Error: err}
 	}
 
-	d.Config.ContainerID = resp.ID
-
-	out, err := d.Client.ContainerLogs(
-		ctx,
-		resp.ID,
-		client.ContainerLogsOptions{
-			ShowStdout: true,
-			ShowStderr: true},
-	)
-	if err != nil {
-		log.Printf("Error getting logs for container %s: %v\n", resp.ID, err)
-		return DockerResult{Error: err}
+	for _, m := range c.Sidecars {
+		err = d.startSidecar(ctx, c, resp.ID, m)
+		if err != nil {
+			log.Printf("Error starting sidecars of %s: %v\n", c.Name, err)
+			c.ContainerID = resp.ID
+			d.Stop(c)
+			return DockerResult{Error: err}
+		}
 	}
 
-	stdcopy.StdCopy(os.Stdout, os.Stderr, out)
-
 	return DockerResult{
 		ContainerId: resp.ID,
 		Action:      "start",
@@ -201,15 +388,30 @@ func (d *Docker) Run() DockerResult {
 
 }
 
-func (d *Docker) Stop(id string) DockerResult {
+func (d *Docker) Stop(c Config) DockerResult {
+	id := c.ContainerID
 	log.Printf("Attempting to stop container. ID: %v \n", id)
 	ctx := context.Background()
-	_, err := d.Client.ContainerStop(ctx, id, client.ContainerStopOptions{})
+	d.runPreStop(c)
+	opts := client.ContainerStopOptions{
+		Signal:  c.StopSignal,
+		Timeout: c.StopGracePeriod,
+	}
+	if c.ForceStop {
+		kill := 0
+		opts = client.ContainerStopOptions{Signal: "SIGKILL", Timeout: &kill}
+	}
+	// the main container goes first, its sidecars keep serving it until it is gone
+	_, err := d.Client.ContainerStop(ctx, id, opts)
 	if err != nil {
 		log.Printf("Error stopping container %s: %v\n", id, err)
 		return DockerResult{Error: err}
 	}
+	if len(c.Sidecars) > 0 {
+		d.removeSidecars(ctx, id, opts)
+	}
 
+	// RemoveVolumes only covers anonymous volumes, named ones are handled below
 	_, err = d.Client.ContainerRemove(ctx, id, client.ContainerRemoveOptions{
 		RemoveVolumes: true,
 		RemoveLinks:   false,
@@ -221,6 +423,8 @@ func (d *Docker) Stop(id string) DockerResult {
 		return DockerResult{Error: err}
 	}
 
+	d.cleanup(ctx, c)
+
 	return DockerResult{
 		Action: "stop",
 		Result: "success",
@@ -228,3 +432,21 @@ func (d *Docker) Stop(id string) DockerResult {
 	}
 
 }
+
+// cleanup removes what a task's containers leave behind once they are gone:
+// scratch volumes, networks no other task uses, and secret files
+func (d *Docker) cleanup(ctx context.Context, c Config) {
+	mounts := c.Mounts
+	for _, m := range append(c.InitContainers, c.Sidecars...) {
+		mounts = append(mounts, m.Mounts...)
+	}
+	for _, v := range scratchVolumes(mounts) {
+		_, err := d.Client.VolumeRemove(ctx, v, client.VolumeRemoveOptions{})
+		if err != nil {
+			log.Printf("Error removing volume %s: %v\n", v, err)
+		}
+	}
+
+	d.releaseNetworks(c.Networks)
+	removeSecrets(c)
+}
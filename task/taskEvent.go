@@ -7,11 +7,11 @@ import (
 )
 
 type TaskEvent struct {
-	ID        uuid.UUID
-	State     State
-	TimeStamp time.Time
-	Task Task
+	ID           uuid.UUID
+	State        State
+	TimeStamp    time.Time
+	Task         Task
+	RegistryAuth *RegistryAuth     `json:",omitempty"` //attached by the manager when dispatching, never stored
+	Stop         *StopOptions      `json:",omitempty"` //overrides of a stop request
+	Secrets      map[string]string `json:",omitempty"` //secret values by name, attached on dispatch like RegistryAuth
 }
-
-
-
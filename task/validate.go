@@ -0,0 +1,208 @@
+package task
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/moby/moby/api/types/network"
+)
+
+// FieldError is a problem with one field of a task spec
+type FieldError struct {
+	Field   string //path to the field, e.g. Sidecars[0].Image
+	Message string
+}
+
+// ValidationError lists everything wrong with a task spec
+type ValidationError []FieldError
+
+func (v ValidationError) Error() string {
+	msgs := []string{}
+	for _, fe := range v {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return "invalid task: " + strings.Join(msgs, "; ")
+}
+
+func (v *ValidationError) add(field string, format string, args ...any) {
+	*v = append(*v, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+var restartPolicies = []string{"", "no", "always", "unless-stopped", "on-failure"}
+
+// Validate checks a task spec before it is queued. It returns a ValidationError
+// listing every invalid field, or nil.
+func (t *Task) Validate() error {
+	v := ValidationError{}
+
+	switch DriverName(*t) {
+	case DriverExec:
+		if len(t.Cmd) == 0 {
+			v.add("Cmd", "is required by the %s driver", DriverExec)
+		}
+	case DriverDocker, DriverFake:
+		if t.Image == "" {
+			v.add("Image", "is required")
+		}
+	default:
+		v.add("Driver", "unknown driver %q", t.Driver)
+	}
+
+	validateNamespace(&v, t.Namespace)
+	if t.Type != "" && t.Type != TypeService && t.Type != TypeBatch {
+		v.add("Type", "must be %s or %s", TypeService, TypeBatch)
+	}
+	validatePullPolicy(&v, "PullPolicy", t.PullPolicy)
+	validateEnv(&v, "Env", t.Env)
+
+	if t.Memory < 0 {
+		v.add("Memory", "must not be negative")
+	}
+	if t.Disk < 0 {
+		v.add("Disk", "must not be negative")
+	}
+	if t.MaxRuntime < 0 {
+		v.add("MaxRuntime", "must not be negative")
+	}
+	if _, err := stopSignal(t.StopSignal); err != nil {
+		v.add("StopSignal", "%v", err)
+	}
+	if t.StopGracePeriod < 0 {
+		v.add("StopGracePeriod", "must not be negative")
+	}
+	if h := t.PreStop; h != nil {
+		if (len(h.Cmd) == 0) == (h.HTTPGet == "") {
+			v.add("PreStop", "needs exactly one of Cmd and HTTPGet")
+		}
+		if h.HTTPGet != "" && (h.Port < 1 || h.Port > 65535) {
+			v.add("PreStop.Port", "must be between 1 and 65535")
+		}
+		if h.Timeout < 0 {
+			v.add("PreStop.Timeout", "must not be negative")
+		}
+	}
+	if !slices.Contains(restartPolicies, t.RestartPolicy) {
+		v.add("RestartPolicy", "must be one of no, always, unless-stopped or on-failure")
+	}
+
+	for p := range t.ExposedPorts {
+		if !p.IsValid() || p.Num() == 0 {
+			v.add("ExposedPorts", "invalid port %q", p.String())
+		}
+	}
+	// sorted so the errors come out in a stable order
+	for _, container := range slices.Sorted(maps.Keys(t.PortBindings)) {
+		host := t.PortBindings[container]
+		field := fmt.Sprintf("PortBindings[%s]", container)
+		p, err := network.ParsePort(container)
+		if err != nil || p.Num() == 0 {
+			v.add(field, "invalid container port %q", container)
+		}
+		n, err := strconv.Atoi(host)
+		if err != nil || n < 1 || n > 65535 {
+			v.add(field, "invalid host port %q", host)
+		}
+	}
+
+	validateMounts(&v, "Mounts", t.Mounts)
+
+	for i, n := range t.Networks {
+		if n == "" {
+			v.add(fmt.Sprintf("Networks[%d]", i), "must not be empty")
+		}
+	}
+	if len(t.NetworkAliases) > 0 && len(t.Networks) == 0 {
+		v.add("NetworkAliases", "need at least one network")
+	}
+
+	for i, ref := range t.Secrets {
+		field := fmt.Sprintf("Secrets[%d]", i)
+		if ref.Name == "" {
+			v.add(field+".Name", "is required")
+		}
+		if (ref.Env == "") == (ref.File == "") {
+			v.add(field, "needs exactly one of Env and File")
+		}
+		if ref.File != "" && !path.IsAbs(ref.File) {
+			v.add(field+".File", "must be an absolute path")
+		}
+	}
+
+	validateSecurity(&v, t.Security)
+	validateHealthCheck(&v, t.HealthCheck)
+
+	names := map[string]bool{}
+	validateContainers(&v, "InitContainers", t.InitContainers, names)
+	validateContainers(&v, "Sidecars", t.Sidecars, names)
+
+	if len(v) > 0 {
+		return v
+	}
+	return nil
+}
+
+func validatePullPolicy(v *ValidationError, field string, p PullPolicy) {
+	if p != "" && p != PullAlways && p != PullIfNotPresent && p != PullNever {
+		v.add(field, "must be %s, %s or %s", PullAlways, PullIfNotPresent, PullNever)
+	}
+}
+
+func validateEnv(v *ValidationError, field string, env []string) {
+	for i, e := range env {
+		if k, _, ok := strings.Cut(e, "="); !ok || k == "" {
+			v.add(fmt.Sprintf("%s[%d]", field, i), "must look like NAME=value")
+		}
+	}
+}
+
+func validateMounts(v *ValidationError, field string, mounts []Mount) {
+	for i, m := range mounts {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		switch m.Type {
+		case MountBind:
+			if !path.IsAbs(m.Source) {
+				v.add(f+".Source", "must be an absolute host path")
+			}
+		case MountVolume, MountTmpfs:
+		default:
+			v.add(f+".Type", "must be %s, %s or %s", MountBind, MountVolume, MountTmpfs)
+		}
+		if !path.IsAbs(m.Target) {
+			v.add(f+".Target", "must be an absolute path")
+		}
+		if m.Persistent && m.Type != MountVolume {
+			v.add(f+".Persistent", "only applies to volumes")
+		}
+		if m.TmpfsSize < 0 {
+			v.add(f+".TmpfsSize", "must not be negative")
+		}
+	}
+}
+
+// validateContainers checks the members of a task group, names have to be unique across the group
+func validateContainers(v *ValidationError, field string, containers []Container, names map[string]bool) {
+	for i, c := range containers {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		if c.Name == "" {
+			v.add(f+".Name", "is required")
+		} else if names[c.Name] {
+			v.add(f+".Name", "duplicate container name %q", c.Name)
+		}
+		names[c.Name] = true
+		if c.Image == "" {
+			v.add(f+".Image", "is required")
+		}
+		validatePullPolicy(v, f+".PullPolicy", c.PullPolicy)
+		validateEnv(v, f+".Env", c.Env)
+		validateMounts(v, f+".Mounts", c.Mounts)
+		if c.Timeout < 0 {
+			v.add(f+".Timeout", "must not be negative")
+		} else if c.Timeout > 0 && field != "InitContainers" {
+			v.add(f+".Timeout", "only applies to init containers")
+		}
+	}
+}
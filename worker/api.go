@@ -21,9 +21,17 @@ func (a *API) initRouter() {
 		r.Get("/", a.GetTasksHandler)
 		r.Route("/{taskID}", func(r chi.Router) {
 			r.Delete("/", a.StopTaskHandler)
+			r.Get("/logs", a.GetTaskLogsHandler)
+			r.Get("/stats", a.GetTaskStatsHandler)
+			r.Post("/exec", a.ExecTaskHandler)
+			r.Get("/exec", a.ExecTaskInteractiveHandler)
+			r.Post("/pause", a.PauseTaskHandler)
+			r.Post("/resume", a.ResumeTaskHandler)
+			r.Post("/restart", a.RestartTaskHandler)
 		})
 
 	})
+	a.Router.Get("/drivers", a.GetDriversHandler)
 
 }
 
@@ -1,16 +1,28 @@
 package worker
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/arhantbararia/goat/task"
-	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
+var auditLog = log.New(os.Stderr, "[audit] ", log.LstdFlags)
+
+var upgrader = websocket.Upgrader{}
+
 type ErrResponse struct {
 	HTTPStatusCode int
 	Message        string
@@ -34,9 +46,15 @@ func (a *API) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// credentials and secrets are only kept for a start, StartTask hands them on and drops them
+	if te.Task.State == task.Scheduled {
+		a.Worker.AddRegistryAuth(te.Task.ID, te.RegistryAuth)
+		a.Worker.AddSecrets(te.Task.ID, te.Secrets)
+	}
+	a.Worker.AddStopOptions(te.Task.ID, te.Stop)
 	a.Worker.AddTask(te.Task)
 	log.Printf("Added task : %v \n ", te.Task.ID)
-	w.WriteHeader(200)
+	w.WriteHeader(201)
 	json.NewEncoder(w).Encode(te.Task)
 
 }
@@ -47,6 +65,40 @@ func (a *API) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(a.Worker.GetTasks())
 }
 
+func (a *API) GetTaskStatsHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        fmt.Sprintf("invalid task id: %v", err),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	samples, err := a.Worker.TaskUsage(tID)
+	if err != nil {
+		w.WriteHeader(404)
+		e := ErrResponse{
+			HTTPStatusCode: 404,
+			Message:        err.Error(),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(samples)
+}
+
+func (a *API) GetDriversHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(a.Worker.DriverNames())
+}
+
 func (a *API) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	taskID := chi.URLParam(r, "taskID")
 
@@ -62,7 +114,7 @@ func (a *API) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	tID, _ := uuid.Parse(taskID)
-	_, ok := a.Worker.Db[tID]
+	taskToStop, ok := a.Worker.getTask(tID)
 	if !ok {
 		log.Println("No tasks with ID : ", tID)
 		w.WriteHeader(400)
@@ -71,12 +123,23 @@ func (a *API) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 			Message:        "No Tasks found for given ID",
 		}
 		json.NewEncoder(w).Encode(e)
+		return
+	}
 
+	opts, err := task.ParseStopOptions(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        err.Error(),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
 	}
 
-	taskToStop := a.Worker.Db[tID]
-	taskCopy := *taskToStop
+	taskCopy := taskToStop
 	taskCopy.State = task.Completed
+	a.Worker.AddStopOptions(tID, opts)
 	a.Worker.AddTask(taskCopy)
 
 	log.Println("Added Task :", taskToStop.ID)
@@ -85,3 +148,319 @@ func (a *API) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(204)
 
 }
+
+func parseLogOptions(q url.Values) (task.LogOptions, error) {
+	opts := task.LogOptions{}
+	var err error
+
+	if v := q.Get("tail"); v != "" {
+		opts.Tail, err = strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid tail %q: %v", v, err)
+		}
+	}
+	if v := q.Get("since"); v != "" {
+		// either a timestamp or a duration back from now, e.g. 10m
+		opts.Since, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			d, derr := time.ParseDuration(v)
+			if derr != nil {
+				return opts, fmt.Errorf("invalid since %q: expected RFC3339 timestamp or duration", v)
+			}
+			opts.Since = time.Now().Add(-d)
+		}
+	}
+	if v := q.Get("timestamps"); v != "" {
+		opts.Timestamps, err = strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid timestamps %q: %v", v, err)
+		}
+	}
+	if v := q.Get("follow"); v != "" {
+		opts.Follow, err = strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid follow %q: %v", v, err)
+		}
+	}
+
+	return opts, nil
+}
+
+func (a *API) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        fmt.Sprintf("invalid task id: %v", err),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	opts, err := parseLogOptions(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        err.Error(),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	logs, err := a.Worker.TaskLogs(r.Context(), tID, opts)
+	if err != nil {
+		log.Printf("error reading logs of task %v: %v \n", tID, err)
+		w.WriteHeader(404)
+		e := ErrResponse{
+			HTTPStatusCode: 404,
+			Message:        err.Error(),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	defer logs.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(200)
+
+	// flush every chunk so follow=true streams as the task writes
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := logs.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			rc.Flush()
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Printf("error streaming logs of task %v: %v \n", tID, err)
+			return
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+}
+
+func (a *API) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        fmt.Sprintf("invalid task id: %v", err),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	req := task.ExecRequest{}
+	err = d.Decode(&req)
+	if err == nil && len(req.Cmd) == 0 {
+		err = fmt.Errorf("missing Cmd")
+	}
+	if err == nil && req.Timeout < 0 {
+		err = fmt.Errorf("Timeout must not be negative")
+	}
+	if err != nil {
+		msg := fmt.Sprintf("exec request parsing error: %v", err)
+		log.Println(msg)
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        msg,
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	result, err := a.Worker.ExecTask(r.Context(), tID, req)
+	if err != nil {
+		auditLog.Printf("exec task=%v cmd=%q user=%q from=%s error=%v", tID, req.Cmd, req.User, r.RemoteAddr, err)
+		status := 409
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = 504
+			err = fmt.Errorf("command did not exit within %ds", req.Timeout)
+		}
+		w.WriteHeader(status)
+		e := ErrResponse{
+			HTTPStatusCode: status,
+			Message:        err.Error(),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+	auditLog.Printf("exec task=%v cmd=%q user=%q from=%s exit=%d", tID, req.Cmd, req.User, r.RemoteAddr, result.ExitCode)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(result)
+}
+
+// parseExecQuery reads an interactive exec request from the websocket URL
+func parseExecQuery(q url.Values) (task.ExecRequest, error) {
+	req := task.ExecRequest{
+		Cmd:        q["cmd"],
+		Env:        q["env"],
+		WorkingDir: q.Get("workdir"),
+		User:       q.Get("user"),
+	}
+	if len(req.Cmd) == 0 {
+		return req, fmt.Errorf("cmd is required")
+	}
+
+	for name, dst := range map[string]*uint{"height": &req.Height, "width": &req.Width} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return req, fmt.Errorf("invalid %s %q: %v", name, v, err)
+			}
+			*dst = uint(n)
+		}
+	}
+
+	return req, nil
+}
+
+// ExecTaskInteractiveHandler runs a command with a TTY over a websocket. Binary
+// messages carry the terminal stream both ways, text messages from the client
+// carry {"Height":..,"Width":..} resizes, and the last text message sent to the
+// client is {"ExitCode":..}, or {"Error":..} when the command could not be started.
+func (a *API) ExecTaskInteractiveHandler(w http.ResponseWriter, r *http.Request) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        fmt.Sprintf("invalid task id: %v", err),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	req, err := parseExecQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        err.Error(),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	// upgrade first, so a client that can't speak websocket never gets a command run
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("error upgrading exec connection: %v \n", err)
+		return
+	}
+	defer conn.Close()
+
+	session, err := a.Worker.ExecTaskInteractive(r.Context(), tID, req)
+	if err != nil {
+		auditLog.Printf("exec task=%v cmd=%q user=%q interactive from=%s error=%v", tID, req.Cmd, req.User, r.RemoteAddr, err)
+		conn.WriteJSON(struct{ Error string }{err.Error()})
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, ""))
+		return
+	}
+	defer session.Close()
+	auditLog.Printf("exec task=%v cmd=%q user=%q interactive from=%s started", tID, req.Cmd, req.User, r.RemoteAddr)
+
+	go func() {
+		for {
+			kind, data, err := conn.ReadMessage()
+			if err != nil {
+				session.Close()
+				return
+			}
+			if kind == websocket.TextMessage {
+				size := struct{ Height, Width uint }{}
+				if json.Unmarshal(data, &size) == nil {
+					session.Resize(size.Height, size.Width)
+				}
+				continue
+			}
+			if _, err := session.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := session.Read(buf)
+		if n > 0 {
+			if werr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	exitCode, err := session.ExitCode()
+	if err != nil {
+		log.Printf("error reading exit code of exec in task %v: %v \n", tID, err)
+	}
+	auditLog.Printf("exec task=%v cmd=%q user=%q interactive from=%s exit=%d", tID, req.Cmd, req.User, r.RemoteAddr, exitCode)
+
+	conn.WriteJSON(struct{ ExitCode int }{exitCode})
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+func (a *API) PauseTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.taskAction(w, r, a.Worker.PauseTask)
+}
+
+func (a *API) ResumeTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.taskAction(w, r, a.Worker.ResumeTask)
+}
+
+func (a *API) RestartTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.taskAction(w, r, a.Worker.RestartTask)
+}
+
+// taskAction runs a pause, resume or restart on the task of the request and answers with the task
+func (a *API) taskAction(w http.ResponseWriter, r *http.Request, action func(uuid.UUID) (task.Task, error)) {
+	tID, err := uuid.Parse(chi.URLParam(r, "taskID"))
+	if err != nil {
+		w.WriteHeader(400)
+		e := ErrResponse{
+			HTTPStatusCode: 400,
+			Message:        fmt.Sprintf("invalid task id: %v", err),
+		}
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	t, err := action(tID)
+	if err != nil {
+		log.Printf("error on task %v: %v \n", tID, err)
+		e := ErrResponse{HTTPStatusCode: 500, Message: err.Error()}
+		switch {
+		case errors.Is(err, ErrTaskNotFound):
+			e.HTTPStatusCode = 404
+		case errors.Is(err, ErrTaskState):
+			e.HTTPStatusCode = 409
+		}
+		w.WriteHeader(e.HTTPStatusCode)
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(t)
+}
@@ -7,11 +7,12 @@ import (
 )
 
 type Stats struct {
-	MemStats  *linux.MemInfo
-	DiskStats *linux.Disk
-	CpuStats  *linux.CPUStat
-	LoadStats *linux.LoadAvg
-	TaskCount int
+	MemStats   *linux.MemInfo
+	DiskStats  *linux.Disk
+	CpuStats   *linux.CPUStat
+	LoadStats  *linux.LoadAvg
+	TaskCount  int
+	TaskMemory uint64 //bytes used by running tasks, as reported by their drivers
 }
 
 func (s *Stats) MemTotalKb() uint64 {
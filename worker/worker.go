@@ -1,8 +1,13 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/arhantbararia/goat/task"
@@ -12,17 +17,42 @@ import (
 
 var WORKER_SLEEP_TIME = 15
 
+var (
+	ErrTaskNotFound = errors.New("no such task")
+	ErrTaskState    = errors.New("task is not in a state that allows this")
+)
+
+// how often running tasks are checked for processes that exited on their own
+var WORKER_INSPECT_TIME = 10
+
+// how long the container of a task that ended on its own is kept, so its logs can still be read
+var ExitedRetention = time.Hour
+
+// usage samples kept per task, one is taken every stats collection
+const maxUsageSamples = 60
+
 type Worker struct {
 	Name      string
 	Queue     queue.Queue
 	Db        map[uuid.UUID]*task.Task
 	TaskCount int
 	Stats     *Stats
+	Drivers   map[string]task.Driver //runtime drivers this worker can run tasks with
+
+	mu           sync.Mutex                       //guards Queue, Db and the maps below, never held across driver calls
+	registryAuth map[uuid.UUID]*task.RegistryAuth //credentials for tasks waiting to be started
+	secrets      map[uuid.UUID]map[string]string  //secret values for tasks waiting to be started
+	stopOptions  map[uuid.UUID]*task.StopOptions  //overrides for tasks waiting to be stopped
+	usage        map[uuid.UUID][]task.UsageSample //recent resource usage of each task, oldest first
+	locks        map[uuid.UUID]*sync.Mutex        //held by whoever is changing a task through its driver
+	exited       map[uuid.UUID]time.Time          //when tasks whose containers are still kept ended on their own
 }
 
 func (w *Worker) runTask() task.DockerResult {
+	w.mu.Lock()
 	t := w.Queue.Dequeue()
 	if t == nil {
+		w.mu.Unlock()
 		log.Println("No task in the queue")
 		return task.DockerResult{Error: nil}
 
@@ -31,15 +61,18 @@ func (w *Worker) runTask() task.DockerResult {
 	taskQueued := t.(task.Task) //proper type conversion after queue retrieval
 
 	taskPersisted := w.Db[taskQueued.ID]
-	if taskPersisted == nil {
+	// a task that failed to start here may be sent again when the manager retries it
+	if taskPersisted == nil || (taskQueued.State == task.Scheduled && taskPersisted.FinishReason == task.ReasonStartFailed) {
 		//task appeared first time
 		taskPersisted = &taskQueued
 		w.Db[taskQueued.ID] = taskPersisted
 	}
+	persistedState := taskPersisted.State
+	w.mu.Unlock()
 
 	var result task.DockerResult
 
-	if task.ValidaStateTransition(taskPersisted.State, taskQueued.State) {
+	if task.ValidaStateTransition(persistedState, taskQueued.State) {
 
 		switch taskQueued.State {
 		case task.Scheduled:
@@ -51,8 +84,9 @@ func (w *Worker) runTask() task.DockerResult {
 		}
 
 	} else {
-		err := fmt.Errorf("invalid Transition from %v --> %v ", taskPersisted.State, taskQueued.State)
+		err := fmt.Errorf("invalid Transition from %v --> %v ", persistedState, taskQueued.State)
 		result.Error = err
+		w.dropStartValues(taskQueued.ID)
 
 	}
 
@@ -61,47 +95,208 @@ func (w *Worker) runTask() task.DockerResult {
 }
 
 func (w *Worker) AddTask(t task.Task) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	w.Queue.Enqueue(t)
 }
 
-func (w *Worker) StartTask(t task.Task) task.DockerResult {
+func (w *Worker) queued() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.Queue.Len()
+}
 
-	t.StartTime = time.Now().UTC()
+// getTask returns a copy of a task the worker knows
+func (w *Worker) getTask(id uuid.UUID) (task.Task, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t, ok := w.Db[id]
+	if !ok {
+		return task.Task{}, false
+	}
+	return *t, true
+}
+
+func (w *Worker) putTask(t task.Task) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Db[t.ID] = &t
+}
+
+// taskLock returns the lock serializing the driver calls made for one task,
+// so a stop, pause or restart and the inspect loop never act on it at once
+func (w *Worker) taskLock(id uuid.UUID) *sync.Mutex {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.locks == nil {
+		w.locks = make(map[uuid.UUID]*sync.Mutex)
+	}
+	l, ok := w.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		w.locks[id] = l
+	}
+	return l
+}
+
+// tasksIn returns copies of the tasks in one of the given states
+func (w *Worker) tasksIn(states ...task.State) []task.Task {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	tasks := []task.Task{}
+	for _, t := range w.Db {
+		if task.Contains(states, t.State) {
+			tasks = append(tasks, *t)
+		}
+	}
+	return tasks
+}
+
+// AddRegistryAuth keeps the credentials needed to pull the image of a queued task
+func (w *Worker) AddRegistryAuth(id uuid.UUID, auth *task.RegistryAuth) {
+	if auth == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.registryAuth == nil {
+		w.registryAuth = make(map[uuid.UUID]*task.RegistryAuth)
+	}
+	w.registryAuth[id] = auth
+}
+
+// dropStartValues forgets the credentials and secrets of a start that won't happen
+func (w *Worker) dropStartValues(id uuid.UUID) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.registryAuth, id)
+	delete(w.secrets, id)
+}
+
+// AddSecrets keeps the secret values a queued task needs until it is started
+func (w *Worker) AddSecrets(id uuid.UUID, values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.secrets == nil {
+		w.secrets = make(map[uuid.UUID]map[string]string)
+	}
+	w.secrets[id] = values
+}
+
+// AddStopOptions keeps the overrides of a stop request until the task is stopped
+func (w *Worker) AddStopOptions(id uuid.UUID, opts *task.StopOptions) {
+	if opts == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopOptions == nil {
+		w.stopOptions = make(map[uuid.UUID]*task.StopOptions)
+	}
+	w.stopOptions[id] = opts
+}
+
+func (w *Worker) StartTask(t task.Task) task.DockerResult {
+	l := w.taskLock(t.ID)
+	l.Lock()
+	defer l.Unlock()
 
 	config := task.NewConfig(&t)
-	dock := task.NewDocker(config)
+	w.mu.Lock()
+	config.RegistryAuth = w.registryAuth[t.ID]
+	delete(w.registryAuth, t.ID)
+	config.SecretValues = w.secrets[t.ID]
+	delete(w.secrets, t.ID)
+	w.mu.Unlock()
+
+	driver, err := w.driverFor(t)
+	if err != nil {
+		log.Printf("Err running task %v: %v\n", t.ID, err)
+		t.State = task.Failed
+		t.RecordStartFailure(err)
+		w.putTask(t)
+		return task.DockerResult{Error: err}
+	}
 
-	result := dock.Run()
+	result := driver.Start(config)
 
 	if result.Error != nil {
 		log.Printf("Err running task %v: %v\n", t.ID, result.Error)
 		t.State = task.Failed
-		w.Db[t.ID] = &t
+		t.RecordStartFailure(result.Error)
+		w.putTask(t)
 		return result
 	}
 
 	t.ContainerID = result.ContainerId
 	t.State = task.Running
-	w.Db[t.ID] = &t
+	// the runtime, and with it MaxRuntime, counts from the start, not from before the image pull
+	t.StartTime = startedAt(driver, result.ContainerId)
+	w.putTask(t)
 
 	return result
 
 }
 
+// startedAt is when the driver says a task's process started, now when it can't tell
+func startedAt(driver task.Driver, containerID string) time.Time {
+	status, err := driver.Inspect(containerID)
+	if err != nil || status.StartedAt.IsZero() {
+		return time.Now().UTC()
+	}
+	return status.StartedAt
+}
+
 func (w *Worker) StopTask(t task.Task) task.DockerResult {
+	l := w.taskLock(t.ID)
+	l.Lock()
+	defer l.Unlock()
+
+	// a restart since the stop was queued may have moved the task to a new container
+	if cur, ok := w.getTask(t.ID); ok && cur.ContainerID != "" {
+		t.ContainerID = cur.ContainerID
+	}
+
 	config := task.NewConfig(&t)
-	dock := task.NewDocker(config)
+	w.mu.Lock()
+	config.ApplyStopOptions(w.stopOptions[t.ID])
+	delete(w.stopOptions, t.ID)
+	w.mu.Unlock()
+
+	driver, err := w.driverFor(t)
+	if err != nil {
+		log.Printf("error stopping container: %v , %v \n", t.ContainerID, err)
+		return task.DockerResult{Error: err}
+	}
+
+	w.thaw(driver, t.ID)
 
-	result := dock.Stop(t.ContainerID)
+	// the container is gone after Stop, so look at how it ended first
+	status, inspectErr := driver.Inspect(t.ContainerID)
+
+	result := driver.Stop(config)
 
 	if result.Error != nil {
 		log.Printf("error stopping container: %v , %v \n", t.ContainerID, result.Error)
 		return result
 	}
 
-	t.FinishTime = time.Now().UTC()
 	t.State = task.Completed
-	w.Db[t.ID] = &t
+	if inspectErr == nil && !status.Running {
+		// it ended on its own before the stop reached it, so it ended the way inspectTask would see it
+		t.RecordExit(status)
+		t.State = t.StateAfterExit(status)
+		if t.State == task.Failed && t.Error == "" {
+			t.Error = "service exited unexpectedly"
+		}
+	} else {
+		t.FinishTime = time.Now().UTC()
+		t.FinishReason = task.ReasonStopped
+	}
+	w.putTask(t)
 
 	log.Printf("stopped and removed container %v for task %v \n", t.ContainerID, t.ID)
 
@@ -109,8 +304,206 @@ func (w *Worker) StopTask(t task.Task) task.DockerResult {
 
 }
 
+// thaw unpauses a paused task so it can take its stop signal
+func (w *Worker) thaw(driver task.Driver, id uuid.UUID) {
+	t, ok := w.getTask(id)
+	if !ok || t.State != task.Paused {
+		return
+	}
+	pauser, ok := driver.(task.Pauser)
+	if !ok {
+		return
+	}
+	err := pauser.Unpause(t.ContainerID)
+	if err != nil {
+		log.Printf("error unpausing task %v before stopping it: %v \n", id, err)
+	}
+}
+
+func (w *Worker) driverFor(t task.Task) (task.Driver, error) {
+	name := task.DriverName(t)
+	driver, ok := w.Drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("driver %s is not supported on worker %s", name, w.Name)
+	}
+	return driver, nil
+}
+
+// DriverNames returns the runtime drivers the worker advertises to the manager
+func (w *Worker) DriverNames() []string {
+	names := []string{}
+	for name := range w.Drivers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// TaskLogs streams a task's output, a follow stops when ctx is done
+func (w *Worker) TaskLogs(ctx context.Context, id uuid.UUID, opts task.LogOptions) (io.ReadCloser, error) {
+	t, ok := w.getTask(id)
+	if !ok {
+		return nil, fmt.Errorf("no task with id %v", id)
+	}
+	if t.ContainerID == "" {
+		return nil, fmt.Errorf("task %v has not been started", id)
+	}
+
+	driver, err := w.driverFor(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.Logs(ctx, t.ContainerID, opts)
+}
+
+// execer returns the driver of a running task if it can run commands inside it
+func (w *Worker) execer(id uuid.UUID) (task.Execer, string, error) {
+	t, ok := w.getTask(id)
+	if !ok {
+		return nil, "", fmt.Errorf("no task with id %v", id)
+	}
+	if t.State != task.Running {
+		return nil, "", fmt.Errorf("task %v is not running", id)
+	}
+
+	driver, err := w.driverFor(t)
+	if err != nil {
+		return nil, "", err
+	}
+	execer, ok := driver.(task.Execer)
+	if !ok {
+		return nil, "", fmt.Errorf("driver %s does not support exec", driver.Name())
+	}
+
+	return execer, t.ContainerID, nil
+}
+
+// ExecTask runs a command in a running task, giving up when ctx is done or req.Timeout passes
+func (w *Worker) ExecTask(ctx context.Context, id uuid.UUID, req task.ExecRequest) (task.ExecResult, error) {
+	execer, containerID, err := w.execer(id)
+	if err != nil {
+		return task.ExecResult{}, err
+	}
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Second)
+		defer cancel()
+	}
+	return execer.Exec(ctx, containerID, req)
+}
+
+func (w *Worker) ExecTaskInteractive(ctx context.Context, id uuid.UUID, req task.ExecRequest) (task.ExecSession, error) {
+	execer, containerID, err := w.execer(id)
+	if err != nil {
+		return nil, err
+	}
+	return execer.ExecInteractive(ctx, containerID, req)
+}
+
+// pauser returns a task in one of the given states and its driver, if the driver can pause and restart it
+func (w *Worker) pauser(id uuid.UUID, states ...task.State) (task.Task, task.Pauser, error) {
+	t, ok := w.getTask(id)
+	if !ok {
+		return t, nil, fmt.Errorf("%w: %v", ErrTaskNotFound, id)
+	}
+	if !task.Contains(states, t.State) {
+		return t, nil, fmt.Errorf("%w: task %v is in state %d", ErrTaskState, id, t.State)
+	}
+
+	driver, err := w.driverFor(t)
+	if err != nil {
+		return t, nil, err
+	}
+	pauser, ok := driver.(task.Pauser)
+	if !ok {
+		return t, nil, fmt.Errorf("%w: driver %s cannot pause or restart tasks", ErrTaskState, driver.Name())
+	}
+
+	return t, pauser, nil
+}
+
+// PauseTask freezes a running task
+func (w *Worker) PauseTask(id uuid.UUID) (task.Task, error) {
+	l := w.taskLock(id)
+	l.Lock()
+	defer l.Unlock()
+
+	t, pauser, err := w.pauser(id, task.Running)
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	err = pauser.Pause(t.ContainerID)
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	t.State = task.Paused
+	w.putTask(t)
+	log.Printf("paused task %v \n", id)
+
+	return t, nil
+}
+
+// ResumeTask thaws a paused task
+func (w *Worker) ResumeTask(id uuid.UUID) (task.Task, error) {
+	l := w.taskLock(id)
+	l.Lock()
+	defer l.Unlock()
+
+	t, pauser, err := w.pauser(id, task.Paused)
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	err = pauser.Unpause(t.ContainerID)
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	t.State = task.Running
+	w.putTask(t)
+	log.Printf("resumed task %v \n", id)
+
+	return t, nil
+}
+
+// RestartTask stops a running or paused task and starts it again in place
+func (w *Worker) RestartTask(id uuid.UUID) (task.Task, error) {
+	l := w.taskLock(id)
+	l.Lock()
+	defer l.Unlock()
+
+	t, pauser, err := w.pauser(id, task.Running, task.Paused)
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	if t.State == task.Paused {
+		err = pauser.Unpause(t.ContainerID)
+		if err != nil {
+			return task.Task{}, err
+		}
+	}
+
+	containerID, err := pauser.Restart(task.NewConfig(&t))
+	if err != nil {
+		return task.Task{}, err
+	}
+
+	t.State = task.Running
+	t.ContainerID = containerID
+	t.StartTime = time.Now().UTC()
+	w.putTask(t)
+	log.Printf("restarted task %v \n", id)
+
+	return t, nil
+}
+
 func (w *Worker) GetTasks() []task.Task {
 	//returns all tasks
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	tasks := []task.Task{}
 
 	for _, value := range w.Db {
@@ -127,16 +520,205 @@ func (w *Worker) CollectStats() {
 		log.Println("Collecting state")
 		w.Stats = GetStats()
 		w.Stats.TaskCount = w.TaskCount
+		w.Stats.TaskMemory = w.sampleTasks()
 		time.Sleep(15 * time.Second)
 	}
 }
 
+// sampleTasks records the resource usage of every running task and returns
+// their total memory use. Samples of tasks that finished are dropped.
+func (w *Worker) sampleTasks() uint64 {
+	samples := map[uuid.UUID]task.UsageSample{}
+	var total uint64
+	for _, t := range w.tasksIn(task.Running) {
+		driver, err := w.driverFor(t)
+		if err != nil {
+			continue
+		}
+		usage, err := driver.Stats(t.ContainerID)
+		if err != nil {
+			log.Printf("error reading stats for task %v: %v \n", t.ID, err)
+			continue
+		}
+
+		samples[t.ID] = task.UsageSample{Time: time.Now().UTC(), Usage: usage}
+		total += usage.MemoryUsage
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.usage == nil {
+		w.usage = make(map[uuid.UUID][]task.UsageSample)
+	}
+	for id, sample := range samples {
+		kept := append(w.usage[id], sample)
+		if len(kept) > maxUsageSamples {
+			kept = kept[len(kept)-maxUsageSamples:]
+		}
+		w.usage[id] = kept
+	}
+	for id := range w.usage {
+		if t, ok := w.Db[id]; !ok || t.State == task.Completed || t.State == task.Failed {
+			delete(w.usage, id)
+		}
+	}
+	return total
+}
+
+func (w *Worker) TaskUsage(id uuid.UUID) ([]task.UsageSample, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.Db[id]; !ok {
+		return nil, fmt.Errorf("no task with id %v", id)
+	}
+
+	samples := slices.Clone(w.usage[id])
+	if samples == nil {
+		samples = []task.UsageSample{}
+	}
+	return samples, nil
+}
+
+// inspectTasks finds running tasks whose process has exited and settles their
+// state, and removes the containers of tasks that ended longer than ExitedRetention ago
+func (w *Worker) inspectTasks() {
+	for _, id := range w.expired() {
+		l := w.taskLock(id)
+		if !l.TryLock() {
+			continue
+		}
+		w.removeExited(id)
+		l.Unlock()
+	}
+
+	for _, t := range w.tasksIn(task.Running, task.Paused) {
+		// a task in the middle of a stop, pause or restart is looked at next round
+		l := w.taskLock(t.ID)
+		if !l.TryLock() {
+			continue
+		}
+		w.inspectTask(t.ID)
+		l.Unlock()
+	}
+}
+
+// inspectTask settles the state of one task, called with its task lock held
+func (w *Worker) inspectTask(id uuid.UUID) {
+	t, ok := w.getTask(id)
+	if !ok || (t.State != task.Running && t.State != task.Paused) {
+		return
+	}
+
+	driver, err := w.driverFor(t)
+	if err != nil {
+		return
+	}
+
+	status, err := driver.Inspect(t.ContainerID)
+	if err != nil {
+		log.Printf("error inspecting task %v: %v \n", t.ID, err)
+		t.State = task.Failed
+		t.FinishTime = time.Now().UTC()
+		t.Error = fmt.Sprintf("lost track of container %s: %v", t.ContainerID, err)
+		w.putTask(t)
+		return
+	}
+	if status.Running {
+		t.Members = status.Members
+		t.Health = status.Health
+		w.putTask(t)
+		deadline, ok := t.Deadline()
+		if ok && time.Now().After(deadline) {
+			w.stopOverdue(driver, t)
+		}
+		return
+	}
+
+	tc := t
+	tc.RecordExit(status)
+	tc.State = tc.StateAfterExit(status)
+	if tc.State == task.Failed && tc.Error == "" {
+		tc.Error = "service exited unexpectedly"
+	}
+	log.Printf("task %v exited with code %d, now %v \n", t.ID, status.ExitCode, tc.State)
+
+	// the container stays for its logs until the retention ends
+	w.mu.Lock()
+	w.Db[tc.ID] = &tc
+	if w.exited == nil {
+		w.exited = make(map[uuid.UUID]time.Time)
+	}
+	w.exited[tc.ID] = time.Now()
+	w.mu.Unlock()
+}
+
+// expired returns the tasks that ended on their own longer than ExitedRetention ago
+func (w *Worker) expired() []uuid.UUID {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ids := []uuid.UUID{}
+	for id, at := range w.exited {
+		if time.Since(at) >= ExitedRetention {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// removeExited removes the kept container of a task along with its scratch
+// volumes and networks, called with its task lock held
+func (w *Worker) removeExited(id uuid.UUID) {
+	w.mu.Lock()
+	_, ok := w.exited[id]
+	delete(w.exited, id)
+	w.mu.Unlock()
+
+	t, found := w.getTask(id)
+	if !ok || !found {
+		return
+	}
+	driver, err := w.driverFor(t)
+	if err != nil {
+		return
+	}
+	result := driver.Stop(task.NewConfig(&t))
+	if result.Error != nil {
+		log.Printf("error removing exited container %v: %v \n", t.ContainerID, result.Error)
+	}
+}
+
+// stopOverdue stops a task that ran past its deadline and marks it failed
+func (w *Worker) stopOverdue(driver task.Driver, t task.Task) {
+	log.Printf("task %v exceeded its max runtime of %ds, stopping it \n", t.ID, t.MaxRuntime)
+	w.thaw(driver, t.ID)
+
+	result := driver.Stop(task.NewConfig(&t))
+	if result.Error != nil {
+		log.Printf("error stopping overdue task %v: %v \n", t.ID, result.Error)
+		return
+	}
+
+	t.State = task.Failed
+	t.FinishTime = time.Now().UTC()
+	t.FinishReason = task.ReasonDeadlineExceeded
+	t.Error = fmt.Sprintf("exceeded max runtime of %ds", t.MaxRuntime)
+	w.putTask(t)
+}
+
+func (w *Worker) UpdateTasks() {
+	for {
+		w.inspectTasks()
+		time.Sleep(time.Duration(WORKER_INSPECT_TIME) * time.Second)
+	}
+}
+
 func (w *Worker) RunTasks() {
 	fmt.Println("Running Task collection Loop")
 	for {
 
-		fmt.Println("Queued Tasks: ", w.Queue.Len())
-		if w.Queue.Len() != 0 {
+		queued := w.queued()
+		fmt.Println("Queued Tasks: ", queued)
+		if queued != 0 {
 			result := w.runTask()
 			if result.Error != nil {
 				log.Println("Error running task- ", result.Error)
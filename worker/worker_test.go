@@ -0,0 +1,155 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arhantbararia/goat/task"
+	"github.com/golang-collections/collections/queue"
+	"github.com/google/uuid"
+)
+
+func newTestWorker(opts task.FakeOptions) *Worker {
+	opts.Rand = rand.New(rand.NewSource(1))
+	return &Worker{
+		Name:    "test",
+		Queue:   *queue.New(),
+		Db:      make(map[uuid.UUID]*task.Task),
+		Drivers: map[string]task.Driver{task.DriverDocker: task.NewFake(opts)},
+	}
+}
+
+func TestStartAndStopTask(t *testing.T) {
+	w := newTestWorker(task.FakeOptions{})
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "img", State: task.Scheduled}
+
+	w.AddTask(tk)
+	result := w.runTask()
+	if result.Error != nil {
+		t.Fatalf("start: %v", result.Error)
+	}
+	got, _ := w.getTask(tk.ID)
+	if got.State != task.Running || got.ContainerID == "" {
+		t.Fatalf("got state %v in container %q, want a running task", got.State, got.ContainerID)
+	}
+
+	tk.State = task.Completed
+	w.AddTask(tk)
+	result = w.runTask()
+	if result.Error != nil {
+		t.Fatalf("stop: %v", result.Error)
+	}
+	got, _ = w.getTask(tk.ID)
+	if got.State != task.Completed || got.FinishReason != task.ReasonStopped {
+		t.Fatalf("got state %v finished for %q, want a stopped task", got.State, got.FinishReason)
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	w := newTestWorker(task.FakeOptions{FailStartRate: 1})
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "img", State: task.Scheduled}
+
+	w.AddTask(tk)
+	w.runTask()
+	got, _ := w.getTask(tk.ID)
+	if got.State != task.Failed || got.FinishReason != task.ReasonStartFailed {
+		t.Fatalf("got state %v finished for %q, want a failed start", got.State, got.FinishReason)
+	}
+}
+
+func TestInspectTasksFailsCrashedTask(t *testing.T) {
+	w := newTestWorker(task.FakeOptions{CrashRate: 1, CrashAfter: time.Millisecond})
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "img", State: task.Scheduled}
+
+	w.AddTask(tk)
+	w.runTask()
+	time.Sleep(5 * time.Millisecond)
+	w.inspectTasks()
+
+	got, _ := w.getTask(tk.ID)
+	if got.State != task.Failed || got.ExitCode != 1 {
+		t.Fatalf("got state %v with exit code %d, want failed with exit code 1", got.State, got.ExitCode)
+	}
+}
+
+func TestInspectTasksCompletesBatchTask(t *testing.T) {
+	w := newTestWorker(task.FakeOptions{RunTime: time.Millisecond})
+	tk := task.Task{ID: uuid.New(), Name: "job", Image: "img", Type: task.TypeBatch, State: task.Scheduled}
+
+	w.AddTask(tk)
+	w.runTask()
+	time.Sleep(5 * time.Millisecond)
+	w.inspectTasks()
+
+	got, _ := w.getTask(tk.ID)
+	if got.State != task.Completed {
+		t.Fatalf("got state %v, want a completed batch task", got.State)
+	}
+}
+
+func TestStopTaskKeepsCrash(t *testing.T) {
+	w := newTestWorker(task.FakeOptions{CrashRate: 1, CrashAfter: time.Millisecond})
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "img", State: task.Scheduled}
+
+	w.AddTask(tk)
+	w.runTask()
+	time.Sleep(5 * time.Millisecond)
+
+	// the stop arrives before the inspect loop noticed the crash
+	tk.State = task.Completed
+	w.AddTask(tk)
+	w.runTask()
+
+	got, _ := w.getTask(tk.ID)
+	if got.State != task.Failed || got.ExitCode != 1 {
+		t.Fatalf("got state %v with exit code %d, want failed with exit code 1", got.State, got.ExitCode)
+	}
+}
+
+func TestStartTimeExcludesPull(t *testing.T) {
+	w := newTestWorker(task.FakeOptions{PullDelay: 50 * time.Millisecond})
+	tk := task.Task{ID: uuid.New(), Name: "web", Image: "img", State: task.Scheduled, MaxRuntime: 60}
+
+	before := time.Now()
+	w.AddTask(tk)
+	w.runTask()
+
+	got, _ := w.getTask(tk.ID)
+	if got.StartTime.Sub(before) < 50*time.Millisecond {
+		t.Fatalf("start time %v is before the image was pulled at %v", got.StartTime, before.Add(50*time.Millisecond))
+	}
+}
+
+func TestExitedTaskKeepsLogsUntilRetentionEnds(t *testing.T) {
+	w := newTestWorker(task.FakeOptions{RunTime: time.Millisecond})
+	tk := task.Task{ID: uuid.New(), Name: "job", Image: "img", Type: task.TypeBatch, State: task.Scheduled}
+
+	w.AddTask(tk)
+	w.runTask()
+	time.Sleep(5 * time.Millisecond)
+	w.inspectTasks()
+
+	logs, err := w.TaskLogs(context.Background(), tk.ID, task.LogOptions{})
+	if err != nil {
+		t.Fatalf("logs of an exited task: %v", err)
+	}
+	out, _ := io.ReadAll(logs)
+	logs.Close()
+	if !strings.Contains(string(out), "started") {
+		t.Fatalf("got logs %q, want the task's output", out)
+	}
+
+	retention := ExitedRetention
+	ExitedRetention = 0
+	defer func() { ExitedRetention = retention }()
+	w.inspectTasks()
+
+	_, err = w.TaskLogs(context.Background(), tk.ID, task.LogOptions{})
+	if err == nil {
+		t.Fatal("the container of the task is still there after its retention ended")
+	}
+}